@@ -1,13 +1,171 @@
 package dragontoothmg
 
+import (
+	"errors"
+	"math/bits"
+)
+
+// Errors returned by ApplyChecked, which explain why a move can't be applied.
+var (
+	ErrNoPieceOnSource       = errors.New("No piece on the move's source square.")
+	ErrWrongSide             = errors.New("The piece on the move's source square belongs to the side not to move.")
+	ErrOwnPieceOnDestination = errors.New("The move's destination square is occupied by a friendly piece.")
+	ErrBadPromotion          = errors.New("Invalid promotion: pawns must promote to N, B, R or Q on the last rank only.")
+	ErrIllegalMovement       = errors.New("The piece can't move to the destination square.")
+	ErrCastlingThroughCheck  = errors.New("Castling out of, through, or into check.")
+	ErrLeavesKingInCheck     = errors.New("The move leaves the king in check.")
+	ErrEmptyPocket           = errors.New("The piece to drop is not in the pocket.")
+	ErrGameOver              = errors.New("The game is already over.")
+	ErrMustCapture           = errors.New("A capture is available, so the move must capture.")
+	ErrGivesCheck            = errors.New("The move gives check, which is not allowed.")
+	ErrNullMoveInCheck       = errors.New("A null move can't be made while in check.")
+)
+
+// Applies a move to the board after verifying that it is legal, and returns a function that
+// can be used to unapply it. If the move is illegal, the board is unchanged, and one of the
+// errors above is returned, describing the problem.
+// This is slower than Apply(), but safe to use on untrusted input.
+func (b *Board) ApplyChecked(m Move) (func(), error) {
+	if err := b.checkLegal(m); err != nil {
+		return nil, err
+	}
+	return b.Apply(m), nil
+}
+
 // Applies a move to the board, and returns a function that can be used to unapply it.
 // This function assumes that the given move is valid (i.e., is in the set of moves found by GenerateLegalMoves()).
 // If the move is not valid, this function has undefined behavior.
 func (b *Board) Apply(m Move) func() {
+	return b.ApplyExt(b.ExtendMove(m))
+}
+
+// Passes the turn to the opponent without moving, as in null-move pruning, and returns a
+// function that can be used to unapply it. The en passant square is cleared, and the halfmove
+// clock advances. A null move can't be made in check, or once the game is over.
+func (b *Board) ApplyNull() (func(), error) {
+	if b.OurKingInCheck() {
+		return nil, ErrNullMoveInCheck
+	}
+	if b.variant != Standard && b.variantRuleResult() != NoResult {
+		return nil, ErrGameOver
+	}
+	oldEpCaptureSquare := b.enpassant
+	oldHalfmoveclock := b.Halfmoveclock
+	b.hash ^= uint64(oldEpCaptureSquare)
+	b.enpassant = 0
+	b.Halfmoveclock++
+	if !b.Wtomove {
+		b.Fullmoveno++ // increment after black's move
+	}
+	b.hash ^= whiteToMoveZobristC
+	b.Wtomove = !b.Wtomove
+	return func() {
+		b.hash ^= whiteToMoveZobristC
+		b.Wtomove = !b.Wtomove
+		if !b.Wtomove {
+			b.Fullmoveno--
+		}
+		b.Halfmoveclock = oldHalfmoveclock
+		b.enpassant = oldEpCaptureSquare
+		b.hash ^= uint64(oldEpCaptureSquare)
+	}, nil
+}
+
+// Returns a copy of the board with a move applied, leaving this board unchanged. Since a Board
+// is a plain value, this is cheap, and the copies can be searched in parallel.
+// Like Apply(), this assumes that the move is legal.
+func (b *Board) WithMove(m Move) Board {
+	next := *b
+	var undo UndoInfo
+	next.makeExtMove(next.ExtendMove(m), &undo)
+	return next
+}
+
+// Computes the extended move for a move on this board, by looking up the pieces involved.
+// The move must be valid for this board.
+func (b *Board) ExtendMove(m Move) ExtMove {
+	var ourBitboardPtr, oppBitboardPtr *Bitboards
+	if b.Wtomove {
+		ourBitboardPtr, oppBitboardPtr = &(b.White), &(b.Black)
+	} else {
+		ourBitboardPtr, oppBitboardPtr = &(b.Black), &(b.White)
+	}
+	if drop := m.Drop(); drop != Nothing {
+		return ExtMove(m) | ExtMove(drop)<<16
+	}
+	pieceType, _ := determinePieceType(ourBitboardPtr, uint64(1)<<m.From())
+	capturedPieceType, _ := determinePieceType(oppBitboardPtr, uint64(1)<<m.To())
+	e := ExtMove(m) | ExtMove(pieceType)<<16
+	if pieceType == Pawn && m.To() == b.enpassant && b.enpassant != 0 {
+		return e | ExtMove(Pawn)<<19 | 1<<23
+	}
+	if pieceType == King {
+		if _, isCastle := b.castlingRookFor(m); isCastle {
+			return e | 1<<22
+		}
+	}
+	return e | ExtMove(capturedPieceType)<<19
+}
+
+// Applies an extended move to the board, and returns a function that can be used to unapply it.
+// This is faster than Apply(), because the piece types are not looked up on the board.
+// The move must have been generated for this position, e.g. by GenerateLegalExtMoves().
+func (b *Board) ApplyExt(e ExtMove) func() {
+	var undo UndoInfo
+	b.makeExtMove(e, &undo)
+	return func() {
+		b.UnmakeMove(e.Move(), &undo)
+	}
+}
+
+// The information needed to unmake a move, which MakeMove fills in. It is a plain value,
+// so a search can keep one per ply in an array, and making moves doesn't allocate.
+type UndoInfo struct {
+	move          ExtMove // the move, with the moving and captured pieces
+	castlerights  uint8
+	enpassant     uint8
+	halfmoveclock uint8
+	hash          uint64
+	pawnHash      uint64
+	materialHash  uint64
+	score         Score
+	checks        [2]uint8 // Three-check
+	promoted      uint64   // Crazyhouse
+	pocketed      Piece    // the captured piece added to the Crazyhouse pocket, or Nothing
+
+	// Atomic: the squares around a capture whose pieces exploded, and for each of them in
+	// order, 4 bits for the type of the piece, plus 8 if it was white
+	exploded       uint64
+	explodedPieces uint32
+}
+
+// Applies a move to the board, and stores what is needed to unmake it in undo.
+// Like Apply(), this assumes that the move is legal, but it doesn't allocate.
+func (b *Board) MakeMove(m Move, undo *UndoInfo) {
+	b.makeExtMove(b.ExtendMove(m), undo)
+}
+
+// Applies an extended move to the board, and stores what is needed to unmake it in undo.
+func (b *Board) makeExtMove(e ExtMove, undo *UndoInfo) {
+	undo.move = e
+	undo.castlerights = b.castlerights
+	undo.enpassant = b.enpassant
+	undo.halfmoveclock = b.Halfmoveclock
+	undo.hash = b.hash
+	undo.pawnHash = b.pawnHash
+	undo.materialHash = b.materialHash
+	undo.score = b.score
+	undo.checks = b.checks
+	undo.promoted = b.promoted
+	undo.pocketed = Nothing
+	m := e.Move()
+	if m.Drop() != Nothing {
+		b.applyDrop(m)
+		return
+	}
 	// Configure data about which pieces move
 	var ourBitboardPtr, oppBitboardPtr *Bitboards
 	var epDelta int8                                // add this to the e.p. square to find the captured pawn
-	var oppStartingRankBb, ourStartingRankBb uint64 // the starting rank of out opponent's major pieces
 	// the constant that represents the index into pieceSquareZobristC for the pawn of our color
 	var ourPiecesPawnZobristIndex int
 	var oppPiecesPawnZobristIndex int
@@ -15,31 +173,25 @@ func (b *Board) Apply(m Move) func() {
 		ourBitboardPtr = &(b.White)
 		oppBitboardPtr = &(b.Black)
 		epDelta = -8
-		oppStartingRankBb = onlyRank[7]
-		ourStartingRankBb = onlyRank[0]
 		ourPiecesPawnZobristIndex = 0
 		oppPiecesPawnZobristIndex = 6
 	} else {
 		ourBitboardPtr = &(b.Black)
 		oppBitboardPtr = &(b.White)
 		epDelta = 8
-		oppStartingRankBb = onlyRank[0]
-		ourStartingRankBb = onlyRank[7]
 		b.Fullmoveno++ // increment after black's move
 		ourPiecesPawnZobristIndex = 6
 		oppPiecesPawnZobristIndex = 0
 	}
 	fromBitboard := (uint64(1) << m.From())
-	toBitboard := (uint64(1) << m.To())
-	pieceType, pieceTypeBitboard := determinePieceType(ourBitboardPtr, fromBitboard)
-	castleStatus := 0
+	to := m.To() // where the moving piece lands, which differs for Chess960 castling
+	pieceType := e.Piece()
+	pieceTypeBitboard := pieceBitboard(ourBitboardPtr, pieceType)
+	castled := e.IsCastle()
 	var oldRookLoc, newRookLoc uint8
-	var flippedKsCastle, flippedQsCastle, flippedOppKsCastle, flippedOppQsCastle bool
 
 	// If it is any kind of capture or pawn move, reset halfmove clock.
-	resetHalfmoveClockFrom := -1
-	if IsCapture(m, b) || pieceType == Pawn { 
-		resetHalfmoveClockFrom = int(b.Halfmoveclock)
+	if e.IsCapture() || pieceType == Pawn {
 		b.Halfmoveclock = 0 // reset halfmove clock
 	} else {
 		b.Halfmoveclock++
@@ -47,65 +199,58 @@ func (b *Board) Apply(m Move) func() {
 
 	// King moves strip castling rights
 	if pieceType == King {
-		// TODO(dylhunn): do this without a branch
-		if m.To()-m.From() == 2 { // castle short
-			castleStatus = 1
-			oldRookLoc = m.To() + 1
-			newRookLoc = m.To() - 1
-		} else if int(m.To())-int(m.From()) == -2 { // castle long
-			castleStatus = -1
-			oldRookLoc = m.To() - 2
-			newRookLoc = m.To() + 1
+		if castled {
+			oldRookLoc, _ = b.castlingRookFor(m)
+			to, newRookLoc, _, _ = castlingSquares(m.From(), oldRookLoc)
 		}
 		// King moves always strip castling rights
 		if b.canCastleKingside() {
 			b.flipKingsideCastle()
-			flippedKsCastle = true
 		}
 		if b.canCastleQueenside() {
 			b.flipQueensideCastle()
-			flippedQsCastle = true
 		}
 	}
 
 	// Rook moves strip castling rights
 	if pieceType == Rook {
-		if b.canCastleKingside() && (fromBitboard&onlyFile[7] != 0) &&
-			fromBitboard&ourStartingRankBb != 0 { // king's rook
-			flippedKsCastle = true
+		if b.canCastleKingside() && m.From() == b.castlingRook(true) { // king's rook
 			b.flipKingsideCastle()
-		} else if b.canCastleQueenside() && (fromBitboard&onlyFile[0] != 0) &&
-			fromBitboard&ourStartingRankBb != 0 { // queen's rook
-			flippedQsCastle = true
+		} else if b.canCastleQueenside() && m.From() == b.castlingRook(false) { // queen's rook
 			b.flipQueensideCastle()
 		}
 	}
 
-	// Apply the castling rook movement
-	if castleStatus != 0 {
-		ourBitboardPtr.Rooks |= (uint64(1) << newRookLoc)
-		ourBitboardPtr.All |= (uint64(1) << newRookLoc)
+	// Pick up the castling rook. It is put down after the king moves, since in Chess960
+	// the king and rook might land on each other's starting squares.
+	if castled {
 		ourBitboardPtr.Rooks &= ^(uint64(1) << oldRookLoc)
 		ourBitboardPtr.All &= ^(uint64(1) << oldRookLoc)
 		// Update rook location in hash
 		// (Rook - 1) assumes that "Nothing" precedes "Rook" in the Piece constants list
 		b.hash ^= pieceSquareZobristC[ourPiecesPawnZobristIndex+(Rook-1)][oldRookLoc]
 		b.hash ^= pieceSquareZobristC[ourPiecesPawnZobristIndex+(Rook-1)][newRookLoc]
+		b.trackPiece(b.Wtomove, Rook, oldRookLoc, -1)
+		b.trackPiece(b.Wtomove, Rook, newRookLoc, 1)
 	}
+	toBitboard := (uint64(1) << to)
+
+	// In Atomic, a capture explodes the pieces around it
+	exploded := b.variant == Atomic && e.IsCapture()
 
 	// Is this an e.p. capture? Strip the opponent pawn and reset the e.p. square
 	oldEpCaptureSquare := b.enpassant
-	var actuallyPerformedEpCapture bool = false
-	if pieceType == Pawn && m.To() == oldEpCaptureSquare && oldEpCaptureSquare != 0 {
-		actuallyPerformedEpCapture = true
+	if e.IsEnPassant() {
 		epOpponentPawnLocation := uint8(int8(oldEpCaptureSquare) + epDelta)
 		oppBitboardPtr.Pawns &= ^(uint64(1) << epOpponentPawnLocation)
 		oppBitboardPtr.All &= ^(uint64(1) << epOpponentPawnLocation)
 		// Remove the opponent pawn from the board hash.
 		b.hash ^= pieceSquareZobristC[oppPiecesPawnZobristIndex][epOpponentPawnLocation]
+		b.trackPiece(!b.Wtomove, Pawn, epOpponentPawnLocation, -1)
 	}
 	// Update the en passant square
-	if pieceType == Pawn && (int8(m.To())+2*epDelta == int8(m.From())) { // pawn double push
+	// A pawn double push, except from the first rank in Horde, allows en passant
+	if pieceType == Pawn && (int8(m.To())+2*epDelta == int8(m.From())) && (m.To()/8 == 3 || m.To()/8 == 4) {
 		b.enpassant = uint8(int8(m.To()) + epDelta)
 	} else {
 		b.enpassant = 0
@@ -114,26 +259,21 @@ func (b *Board) Apply(m Move) func() {
 	// Is this a promotion?
 	var destTypeBitboard *uint64
 	var promotedToPieceType Piece // if not promoted, same as pieceType
-	switch m.Promote() {
-	case Queen:
-		destTypeBitboard = &(ourBitboardPtr.Queens)
-		promotedToPieceType = Queen
-	case Knight:
-		destTypeBitboard = &(ourBitboardPtr.Knights)
-		promotedToPieceType = Knight
-	case Rook:
-		destTypeBitboard = &(ourBitboardPtr.Rooks)
-		promotedToPieceType = Rook
-	case Bishop:
-		destTypeBitboard = &(ourBitboardPtr.Bishops)
-		promotedToPieceType = Bishop
-	default:
+	// (a promotion to a king only happens in Antichess)
+	if promote := m.Promote(); promote != Nothing {
+		destTypeBitboard = pieceBitboard(ourBitboardPtr, promote)
+		promotedToPieceType = promote
+	} else {
 		destTypeBitboard = pieceTypeBitboard
 		promotedToPieceType = pieceType
 	}
 
 	// Apply the move
-	capturedPieceType, capturedBitboard := determinePieceType(oppBitboardPtr, toBitboard)
+	capturedPieceType := e.Captured()
+	if e.IsEnPassant() { // the captured pawn was already removed
+		capturedPieceType = Nothing
+	}
+	capturedBitboard := pieceBitboard(oppBitboardPtr, capturedPieceType)
 	ourBitboardPtr.All &= ^fromBitboard // remove at "from"
 	ourBitboardPtr.All |= toBitboard    // add at "to"
 	*pieceTypeBitboard &= ^fromBitboard // remove at "from"
@@ -142,20 +282,49 @@ func (b *Board) Apply(m Move) func() {
 		*capturedBitboard &= ^toBitboard
 		oppBitboardPtr.All &= ^toBitboard
 		b.hash ^= pieceSquareZobristC[oppPiecesPawnZobristIndex+(int(capturedPieceType)-1)][m.To()] // remove the captured piece from the hash
+		b.trackPiece(!b.Wtomove, capturedPieceType, m.To(), -1)
 	}
 	b.hash ^= pieceSquareZobristC[(int(pieceType)-1)+ourPiecesPawnZobristIndex][m.From()]         // remove piece at "from"
-	b.hash ^= pieceSquareZobristC[(int(promotedToPieceType)-1)+ourPiecesPawnZobristIndex][m.To()] // add piece at "to"
+	b.hash ^= pieceSquareZobristC[(int(promotedToPieceType)-1)+ourPiecesPawnZobristIndex][to]     // add piece at "to"
+	b.trackPiece(b.Wtomove, pieceType, m.From(), -1)
+	b.trackPiece(b.Wtomove, promotedToPieceType, to, 1)
+
+	// Put down the castling rook
+	if castled {
+		ourBitboardPtr.Rooks |= (uint64(1) << newRookLoc)
+		ourBitboardPtr.All |= (uint64(1) << newRookLoc)
+	}
 
 	// If a rook was captured, it strips castling rights
 	if capturedPieceType == Rook {
-		if m.To()%8 == 7 && toBitboard&oppStartingRankBb != 0 && b.oppCanCastleKingside() { // captured king rook
+		if m.To() == b.oppCastlingRook(true) && b.oppCanCastleKingside() { // captured king rook
 			b.flipOppKingsideCastle()
-			flippedOppKsCastle = true
-		} else if m.To()%8 == 0 && toBitboard&oppStartingRankBb != 0 && b.oppCanCastleQueenside() { // queen rooks
+		} else if m.To() == b.oppCastlingRook(false) && b.oppCanCastleQueenside() { // queen rooks
 			b.flipOppQueensideCastle()
-			flippedOppQsCastle = true
 		}
 	}
+	// In Atomic, the capture explodes the capturing piece, and every piece but pawns around it
+	if exploded {
+		b.saveExploded(m.To(), undo)
+		b.explode(m.To())
+	}
+
+	// In Crazyhouse, the captured piece goes to our pocket, and promoted pieces are tracked
+	if b.variant == Crazyhouse {
+		if e.IsCapture() {
+			undo.pocketed = e.Captured()
+			if b.promoted&toBitboard != 0 { // a captured promoted piece becomes a pawn again
+				undo.pocketed = Pawn
+			}
+			b.addToPocket(b.Wtomove, undo.pocketed)
+		}
+		if b.promoted&fromBitboard != 0 || m.Promote() != Nothing {
+			b.promoted = b.promoted&^fromBitboard | toBitboard
+		} else {
+			b.promoted &^= toBitboard
+		}
+	}
+
 	// flip the side to move in the hash
 	b.hash ^= whiteToMoveZobristC
 	b.Wtomove = !b.Wtomove
@@ -164,79 +333,247 @@ func (b *Board) Apply(m Move) func() {
 	b.hash ^= uint64(oldEpCaptureSquare)
 	b.hash ^= uint64(b.enpassant)
 
-	// Return the unapply function (closure)
-	unapply := func() {
-		// Flip the player to move
-		b.hash ^= whiteToMoveZobristC
-		b.Wtomove = !b.Wtomove
+	// In Three-check, count the checks
+	if b.variant == ThreeCheck && b.OurKingInCheck() {
+		b.addCheck(!b.Wtomove)
+	}
+}
 
-		// Restore the halfmove clock
-		if resetHalfmoveClockFrom == -1 {
-			b.Halfmoveclock--
-		} else {
-			b.Halfmoveclock = uint8(resetHalfmoveClockFrom)
-		}
+// Unmakes a move made by MakeMove, using the undo information it stored.
+// The move must be the last one made on the board.
+func (b *Board) UnmakeMove(m Move, undo *UndoInfo) {
+	// Flip the player to move
+	b.Wtomove = !b.Wtomove
+	if !b.Wtomove {
+		b.Fullmoveno-- // decrement after undoing black's move
+	}
+	var ourBitboardPtr, oppBitboardPtr *Bitboards
+	var epDelta int8 = 8
+	if b.Wtomove {
+		ourBitboardPtr, oppBitboardPtr, epDelta = &(b.White), &(b.Black), -8
+	} else {
+		ourBitboardPtr, oppBitboardPtr = &(b.Black), &(b.White)
+	}
 
-		// Unapply move
-		ourBitboardPtr.All &= ^toBitboard                                                             // remove at "to"
-		ourBitboardPtr.All |= fromBitboard                                                            // add at "from"
-		*destTypeBitboard &= ^toBitboard                                                              // remove at "to"
-		*pieceTypeBitboard |= fromBitboard                                                            // add at "from"
-		b.hash ^= pieceSquareZobristC[(int(promotedToPieceType)-1)+ourPiecesPawnZobristIndex][m.To()] // remove the piece at "to"
-		b.hash ^= pieceSquareZobristC[(int(pieceType)-1)+ourPiecesPawnZobristIndex][m.From()]         // add the piece at "from"
-
-		// Restore captured piece (excluding e.p.)
-		if capturedPieceType != Nothing { // doesn't consider e.p. captures
-			*capturedBitboard |= toBitboard
-			oppBitboardPtr.All |= toBitboard
-			// restore the captured piece to the hash (excluding e.p.)
-			b.hash ^= pieceSquareZobristC[oppPiecesPawnZobristIndex+(int(capturedPieceType)-1)][m.To()]
-		}
+	// Restore the pockets first, since they update the hash, and then the saved state
+	toBitboard := uint64(1) << m.To()
+	drop := m.Drop()
+	if undo.pocketed != Nothing {
+		b.removeFromPocket(b.Wtomove, undo.pocketed)
+	} else if drop != Nothing {
+		b.addToPocket(b.Wtomove, drop)
+	}
+	b.castlerights = undo.castlerights
+	b.enpassant = undo.enpassant
+	b.Halfmoveclock = undo.halfmoveclock
+	b.hash = undo.hash
+	b.pawnHash = undo.pawnHash
+	b.materialHash = undo.materialHash
+	b.score = undo.score
+	b.checks = undo.checks
+	b.promoted = undo.promoted
 
-		// Restore rooks from castling move
-		if castleStatus != 0 {
-			ourBitboardPtr.Rooks &= ^(uint64(1) << newRookLoc)
-			ourBitboardPtr.All &= ^(uint64(1) << newRookLoc)
-			ourBitboardPtr.Rooks |= (uint64(1) << oldRookLoc)
-			ourBitboardPtr.All |= (uint64(1) << oldRookLoc)
-			// Revert castling rook move
-			b.hash ^= pieceSquareZobristC[ourPiecesPawnZobristIndex+(Rook-1)][oldRookLoc]
-			b.hash ^= pieceSquareZobristC[ourPiecesPawnZobristIndex+(Rook-1)][newRookLoc]
-		}
+	if drop != Nothing { // take the dropped piece off the board
+		*pieceBitboard(ourBitboardPtr, drop) &^= toBitboard
+		ourBitboardPtr.All &^= toBitboard
+		return
+	}
+	fromBitboard := uint64(1) << m.From()
+	pieceType := undo.move.Piece()
+	if undo.move.IsCastle() { // pick up the king and rook, and put them back
+		oldRookLoc := b.castlingRook(m.To() > m.From())
+		kingDest, newRookLoc, _, _ := castlingSquares(m.From(), oldRookLoc)
+		ourBitboardPtr.Kings = ourBitboardPtr.Kings&^(uint64(1)<<kingDest) | fromBitboard
+		ourBitboardPtr.Rooks = ourBitboardPtr.Rooks&^(uint64(1)<<newRookLoc) | uint64(1)<<oldRookLoc
+		ourBitboardPtr.All = ourBitboardPtr.All&^(uint64(1)<<kingDest)&^(uint64(1)<<newRookLoc) |
+			fromBitboard | uint64(1)<<oldRookLoc
+		return
+	}
 
-		// Unapply en-passant square change, and capture if necessary
-		b.hash ^= uint64(b.enpassant)        // undo the new en passant square from the hash
-		b.hash ^= uint64(oldEpCaptureSquare) // restore the old one to the hash
-		b.enpassant = oldEpCaptureSquare
-		if actuallyPerformedEpCapture {
-			epOpponentPawnLocation := uint8(int8(oldEpCaptureSquare) + epDelta)
-			oppBitboardPtr.Pawns |= (uint64(1) << epOpponentPawnLocation)
-			oppBitboardPtr.All |= (uint64(1) << epOpponentPawnLocation)
-			// Add the opponent pawn to the board hash.
-			b.hash ^= pieceSquareZobristC[oppPiecesPawnZobristIndex][epOpponentPawnLocation]
-		}
+	// Unapply move
+	destType := pieceType
+	if promote := m.Promote(); promote != Nothing {
+		destType = promote
+	}
+	*pieceBitboard(ourBitboardPtr, destType) &^= toBitboard // remove at "to"
+	*pieceBitboard(ourBitboardPtr, pieceType) |= fromBitboard
+	ourBitboardPtr.All = ourBitboardPtr.All&^toBitboard | fromBitboard
 
-		// Decrement move clock
-		if !b.Wtomove {
-			b.Fullmoveno-- // decrement after undoing black's move
-		}
+	// Restore the captured piece
+	if undo.move.IsEnPassant() {
+		epOpponentPawnLocation := uint8(int8(m.To()) + epDelta)
+		oppBitboardPtr.Pawns |= uint64(1) << epOpponentPawnLocation
+		oppBitboardPtr.All |= uint64(1) << epOpponentPawnLocation
+	} else if captured := undo.move.Captured(); captured != Nothing {
+		*pieceBitboard(oppBitboardPtr, captured) |= toBitboard
+		oppBitboardPtr.All |= toBitboard
+	}
 
-		// Restore castling flags
-		// Must update castling flags AFTER turn swap
-		if flippedKsCastle {
-			b.flipKingsideCastle()
+	// In Atomic, the capturing piece is back, but the pieces around it must be restored
+	if b.variant == Atomic && undo.move.IsCapture() {
+		b.restoreExploded(undo)
+	}
+}
+
+// Saves the pieces that an Atomic capture on square will explode around it, so that
+// UnmakeMove can restore them. The capturing and captured pieces are known from the move.
+func (b *Board) saveExploded(square uint8, undo *UndoInfo) {
+	undo.exploded = kingMasks[square] & (b.White.All | b.Black.All) &^ (b.White.Pawns | b.Black.Pawns)
+	undo.explodedPieces = 0
+	shift := uint(0)
+	for squares := undo.exploded; squares != 0; squares &= squares - 1 {
+		squareBitboard := squares & -squares
+		if p, _ := determinePieceType(&(b.White), squareBitboard); p != Nothing {
+			undo.explodedPieces |= uint32(p|8) << shift
+		} else {
+			p, _ = determinePieceType(&(b.Black), squareBitboard)
+			undo.explodedPieces |= uint32(p) << shift
 		}
-		if flippedQsCastle {
-			b.flipQueensideCastle()
+		shift += 4
+	}
+}
+
+// Puts back the pieces around an Atomic capture that were saved by saveExploded.
+func (b *Board) restoreExploded(undo *UndoInfo) {
+	pieces := undo.explodedPieces
+	for squares := undo.exploded; squares != 0; squares &= squares - 1 {
+		squareBitboard := squares & -squares
+		side := &(b.Black)
+		if pieces&8 != 0 {
+			side = &(b.White)
 		}
-		if flippedOppKsCastle {
-			b.flipOppKingsideCastle()
+		*pieceBitboard(side, Piece(pieces&7)) |= squareBitboard
+		side.All |= squareBitboard
+		pieces >>= 4
+	}
+}
+
+// Applies a Crazyhouse drop to the board. MakeMove has already saved what is needed to
+// unmake it.
+func (b *Board) applyDrop(m Move) {
+	piece := m.Drop()
+	ourBitboardPtr, zobristIndex := &(b.Black), 6+int(piece)-1
+	if b.Wtomove {
+		ourBitboardPtr, zobristIndex = &(b.White), int(piece)-1
+	}
+	toBitboard := uint64(1) << m.To()
+	b.removeFromPocket(b.Wtomove, piece)
+	*pieceBitboard(ourBitboardPtr, piece) |= toBitboard
+	ourBitboardPtr.All |= toBitboard
+	b.hash ^= pieceSquareZobristC[zobristIndex][m.To()]
+	b.trackPiece(b.Wtomove, piece, m.To(), 1)
+
+	b.hash ^= uint64(b.enpassant)
+	b.enpassant = 0
+	if piece == Pawn { // like a pawn move, a pawn drop is irreversible
+		b.Halfmoveclock = 0
+	} else {
+		b.Halfmoveclock++
+	}
+	if !b.Wtomove {
+		b.Fullmoveno++ // increment after black's move
+	}
+	b.hash ^= whiteToMoveZobristC
+	b.Wtomove = !b.Wtomove
+}
+
+// Explodes the piece on square, and every piece but pawns next to it, as for an Atomic capture.
+// Exploded kings and rooks lose their castling rights.
+func (b *Board) explode(square uint8) {
+	blast := atomicBlast(square, (b.White.All|b.Black.All)&^(b.White.Pawns|b.Black.Pawns))
+	whiteKingExploded := b.White.Kings&blast != 0
+	blackKingExploded := b.Black.Kings&blast != 0
+	for p := Piece(Pawn); p <= King; p++ {
+		for pieces := *pieceBitboard(&(b.White), p) & blast; pieces != 0; pieces &= pieces - 1 {
+			b.hash ^= pieceSquareZobristC[p-1][bits.TrailingZeros64(pieces)]
+			b.trackPiece(true, p, uint8(bits.TrailingZeros64(pieces)), -1)
 		}
-		if flippedOppQsCastle {
-			b.flipOppQueensideCastle()
+		for pieces := *pieceBitboard(&(b.Black), p) & blast; pieces != 0; pieces &= pieces - 1 {
+			b.hash ^= pieceSquareZobristC[6+p-1][bits.TrailingZeros64(pieces)]
+			b.trackPiece(false, p, uint8(bits.TrailingZeros64(pieces)), -1)
 		}
+		*pieceBitboard(&(b.White), p) &^= blast
+		*pieceBitboard(&(b.Black), p) &^= blast
+	}
+	b.White.All &^= blast
+	b.Black.All &^= blast
+	if b.whiteCanCastleQueenside() && (whiteKingExploded || blast&(uint64(1)<<b.castleRooks[0]) != 0) {
+		b.flipWhiteQueensideCastle()
+	}
+	if b.whiteCanCastleKingside() && (whiteKingExploded || blast&(uint64(1)<<b.castleRooks[1]) != 0) {
+		b.flipWhiteKingsideCastle()
+	}
+	if b.blackCanCastleQueenside() && (blackKingExploded || blast&(uint64(1)<<b.castleRooks[2]) != 0) {
+		b.flipBlackQueensideCastle()
+	}
+	if b.blackCanCastleKingside() && (blackKingExploded || blast&(uint64(1)<<b.castleRooks[3]) != 0) {
+		b.flipBlackKingsideCastle()
+	}
+}
+
+// Updates the pawn and material hashes, and the score, for a piece added to a square, or with
+// sign -1, removed from it. The main hash is updated separately.
+func (b *Board) trackPiece(white bool, p Piece, square uint8, sign int32) {
+	zobristIndex := int(p) - 1
+	if !white {
+		zobristIndex += 6
+	}
+	if p == Pawn || p == King {
+		b.pawnHash ^= pieceSquareZobristC[zobristIndex][square]
+	}
+	if sign > 0 {
+		b.materialHash += materialZobristC[zobristIndex]
+	} else {
+		b.materialHash -= materialZobristC[zobristIndex]
+	}
+	b.scorePiece(white, p, square, sign)
+}
+
+// Counts a check given by a side in Three-check, and updates the hash.
+func (b *Board) addCheck(white bool) {
+	count := &b.checks[sideIndex(white)]
+	b.hash ^= checksZobristC[sideIndex(white)][*count]
+	*count++
+}
+
+// Takes back a check given by a side in Three-check, and updates the hash.
+func (b *Board) removeCheck(white bool) {
+	count := &b.checks[sideIndex(white)]
+	*count--
+	b.hash ^= checksZobristC[sideIndex(white)][*count]
+}
+
+// Adds a piece to a side's Crazyhouse pocket, and updates the hash.
+func (b *Board) addToPocket(white bool, p Piece) {
+	count := &b.pockets[sideIndex(white)][p-1]
+	b.hash ^= pocketZobristC[sideIndex(white)][p-1][*count]
+	*count++
+}
+
+// Removes a piece from a side's Crazyhouse pocket, and updates the hash.
+func (b *Board) removeFromPocket(white bool, p Piece) {
+	count := &b.pockets[sideIndex(white)][p-1]
+	*count--
+	b.hash ^= pocketZobristC[sideIndex(white)][p-1][*count]
+}
+
+// Returns the bitboard for a piece type, or the bitboard of all pieces for Nothing.
+func pieceBitboard(ourBitboardPtr *Bitboards, pieceType Piece) *uint64 {
+	switch pieceType {
+	case Pawn:
+		return &(ourBitboardPtr.Pawns)
+	case Knight:
+		return &(ourBitboardPtr.Knights)
+	case Bishop:
+		return &(ourBitboardPtr.Bishops)
+	case Rook:
+		return &(ourBitboardPtr.Rooks)
+	case Queen:
+		return &(ourBitboardPtr.Queens)
+	case King:
+		return &(ourBitboardPtr.Kings)
 	}
-	return unapply
+	return &(ourBitboardPtr.All)
 }
 
 func determinePieceType(ourBitboardPtr *Bitboards, squareMask uint64) (Piece, *uint64) {
@@ -138,3 +138,296 @@ func TestApplyUnapply(t *testing.T) {
 		}*/
 	}
 }
+
+func TestApplyChecked(t *testing.T) {
+	tests := []struct {
+		fen  string
+		move string
+		err  error
+	}{
+		{Startpos, "e2e4", nil},
+		{Startpos, "e3e4", ErrNoPieceOnSource},
+		{Startpos, "e7e5", ErrWrongSide},
+		{Startpos, "a1a2", ErrOwnPieceOnDestination},
+		{Startpos, "e2e5", ErrIllegalMovement},
+		{Startpos, "g1g3", ErrIllegalMovement},
+		{Startpos, "e1g1", ErrOwnPieceOnDestination},
+		{Startpos, "e2e4q", ErrBadPromotion},
+		{Startpos, "g1f3n", ErrBadPromotion},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8", ErrBadPromotion},
+		{"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7b8r", nil},
+		{"4k3/8/8/8/8/8/3r4/R3K2R w KQ - 0 1", "e1c1", ErrCastlingThroughCheck},
+		{"4k3/8/8/8/8/8/3r4/R3K2R w KQ - 0 1", "e1g1", nil},
+		{"4k3/8/8/8/8/8/5r2/R3K2R w KQ - 0 1", "e1g1", ErrCastlingThroughCheck},
+		{"4k3/8/8/8/8/8/6r1/R3K2R w KQ - 0 1", "e1g1", ErrCastlingThroughCheck},
+		{"4k3/8/8/8/8/8/7r/R3K2R w KQ - 0 1", "e1g1", nil},
+		{"4k3/8/8/8/8/8/8/R3KN1R w KQ - 0 1", "e1g1", ErrIllegalMovement},
+		{"4k3/8/8/8/8/8/8/R3K2R w Q - 0 1", "e1g1", ErrIllegalMovement},
+		{"4k3/8/8/8/8/8/8/R3K2r w KQ - 0 1", "e1g1", ErrCastlingThroughCheck},
+		{"4k3/8/8/8/8/8/3r4/4K3 w - - 0 1", "e1d1", ErrLeavesKingInCheck},
+		{"4k3/4r3/8/8/8/8/4B3/4K3 w - - 0 1", "e2d3", ErrLeavesKingInCheck},
+		{"1k6/8/8/K2pP2r/8/8/8/8 w - d6 0 1", "e5d6", ErrLeavesKingInCheck},
+		{"1k6/8/8/K2pP2r/8/8/8/8 w - d6 0 1", "e5e6", nil},
+	}
+	for _, test := range tests {
+		b := ParseFen(test.fen)
+		m := parseMove(test.move)
+		unapply, err := b.ApplyChecked(m)
+		if err != test.err {
+			t.Error("ApplyChecked returned", err, "instead of", test.err, "for move", test.move,
+				"in position\n", test.fen)
+		}
+		if err != nil {
+			if b.ToFen() != test.fen {
+				t.Error("ApplyChecked changed the board for illegal move", test.move)
+			}
+			continue
+		}
+		unapply()
+		if b.ToFen() != test.fen {
+			t.Error("ApplyChecked didn't unapply cleanly for move", test.move)
+		}
+	}
+}
+
+func TestExtendMove(t *testing.T) {
+	tests := []struct {
+		fen       string
+		move      string
+		piece     Piece
+		captured  Piece
+		castle    bool
+		enpassant bool
+	}{
+		{Startpos, "e2e4", Pawn, Nothing, false, false},
+		{Startpos, "g1f3", Knight, Nothing, false, false},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", King, Nothing, true, false},
+		{"r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "e8c8", King, Nothing, true, false},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1f1", King, Nothing, false, false},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "a1a8", Rook, Rook, false, false},
+		{"1k6/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", Pawn, Pawn, false, true},
+		{"1k6/8/8/8/3Pp3/8/8/4K3 b - d3 0 1", "e4d3", Pawn, Pawn, false, true},
+		{"2n1k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "b7c8q", Pawn, Knight, false, false},
+	}
+	for _, test := range tests {
+		b := ParseFen(test.fen)
+		e := b.ExtendMove(parseMove(test.move))
+		if e.Move() != parseMove(test.move) || e.String() != test.move {
+			t.Error("ExtMove", &e, "doesn't convert back to", test.move)
+		}
+		if e.Piece() != test.piece || e.Captured() != test.captured ||
+			e.IsCastle() != test.castle || e.IsEnPassant() != test.enpassant {
+			t.Error("ExtMove for", test.move, "has piece", e.Piece(), "captured", e.Captured(),
+				"castle", e.IsCastle(), "en passant", e.IsEnPassant(), "in position\n", test.fen)
+		}
+	}
+	// the generator fills in the same information as ExtendMove
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		walkPositions(&b, 2, func(b *Board) {
+			moves, extMoves := b.GenerateLegalMoves(), b.GenerateLegalExtMoves()
+			if len(moves) != len(extMoves) {
+				t.Error("GenerateLegalExtMoves found", len(extMoves), "moves instead of", len(moves))
+				return
+			}
+			for i := range moves {
+				if extMoves[i] != b.ExtendMove(moves[i]) {
+					t.Error("GenerateLegalExtMoves returned", &extMoves[i], "with piece", extMoves[i].Piece(),
+						"and captured", extMoves[i].Captured(), "instead of", &moves[i], "in position\n", b.ToFen())
+				}
+			}
+		})
+	}
+}
+
+func TestCrazyhouseApply(t *testing.T) {
+	tests := []struct {
+		fen    string
+		move   string
+		result string
+	}{
+		// captured pieces go to the capturer's pocket
+		{"4k3/8/8/3n4/8/8/8/3RK3[] w - - 0 1", "d1d5", "4k3/8/8/3R4/8/8/8/4K3[N] b - - 0 1"},
+		{"4k3/8/8/8/4Pp2/8/8/4K3[] b - e3 0 1", "f4e3", "4k3/8/8/8/8/4p3/8/4K3[p] w - - 0 2"},
+		// promoted pieces are remembered, and return to the pocket as pawns
+		{"4k3/1P6/8/8/8/8/8/4K3[] w - - 0 1", "b7b8q", "1Q~2k3/8/8/8/8/8/8/4K3[] b - - 0 1"},
+		{"1Q~2k3/8/8/8/8/8/8/4K3[] b - - 0 1", "e8d8", "1Q~1k4/8/8/8/8/8/8/4K3[] w - - 1 2"},
+		{"1Q~1k3/8/8/8/8/8/8/4K3[] w - - 0 1", "b8b1", "3k4/8/8/8/8/8/8/1Q~2K3[] b - - 1 1"},
+		{"1n2k3/8/8/8/8/8/8/1Q~2K3[] b - - 0 1", "b8a6", "4k3/8/n7/8/8/8/8/1Q~2K3[] w - - 1 2"},
+		{"4k3/8/8/8/8/8/3q4/1Q~2K3[] b - - 0 1", "d2b2", "4k3/8/8/8/8/8/1q6/1Q~2K3[] w - - 1 2"},
+		{"4k3/8/8/8/8/8/1q6/1Q~2K3[] b - - 0 1", "b2b1", "4k3/8/8/8/8/8/8/1q2K3[p] w - - 0 2"},
+		// dropping a piece empties the pocket
+		{"4k3/8/8/8/8/8/8/4K3[NNp] w - - 5 1", "N@f3", "4k3/8/8/8/8/5N2/8/4K3[Np] b - - 6 1"},
+		{"4k3/8/8/8/8/8/8/4K3[NNp] b - - 5 1", "P@e2", "4k3/8/8/8/8/8/4p3/4K3[NN] w - - 0 2"},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Crazyhouse)
+		unapply := b.Apply(parseMove(test.move))
+		if fen := b.ToFen(); fen != test.result {
+			t.Error("Applying", test.move, "to", test.fen, "resulted in", fen, "instead of", test.result)
+		}
+		if b.Hash() != recomputeBoardHash(&b) {
+			t.Error("Applying", test.move, "updated the hash incorrectly in position\n", test.fen)
+		}
+		unapply()
+		if original := ParseFenVariant(test.fen, Crazyhouse); b != original {
+			t.Error("Move", test.move, "did not unapply cleanly in position\n", test.fen)
+		}
+	}
+}
+
+func TestAtomicApply(t *testing.T) {
+	tests := []struct {
+		fen    string
+		move   string
+		result string
+	}{
+		// the capturing and captured pieces explode, but neighboring pawns survive
+		{"rnbqkbnr/ppp1pppp/8/3p4/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2", "e4d5",
+			"rnbqkbnr/ppp1pppp/8/8/8/8/PPPP1PPP/RNBQKBNR b KQkq - 0 2"},
+		// an exploded king loses its castling rights
+		{"rnbqkbnr/pppp1ppp/8/4N3/8/8/PPPPPPPP/RNBQKB1R w KQkq - 0 1", "e5f7",
+			"rnbq3r/pppp2pp/8/8/8/8/PPPPPPPP/RNBQKB1R b KQ - 0 1"},
+		// exploded rooks lose their castling rights
+		{"r3k2r/8/8/8/4b3/8/6P1/R3K1NR b KQkq - 0 1", "e4g2", "r3k2r/8/8/8/8/8/8/R3K3 w Qkq - 0 2"},
+		// en passant
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", "4k3/8/8/8/8/8/8/4K3 b - - 0 1"},
+		// exploding the opponent king
+		{"4k3/4p3/8/8/1B6/8/8/r3K3 w - - 0 1", "b4e7", "8/8/8/8/8/8/8/r3K3 b - - 0 1"},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Atomic)
+		unapply := b.Apply(parseMove(test.move))
+		if fen := b.ToFen(); fen != test.result {
+			t.Error("Applying", test.move, "to", test.fen, "resulted in", fen, "instead of", test.result)
+		}
+		if b.Hash() != recomputeBoardHash(&b) {
+			t.Error("Applying", test.move, "updated the hash incorrectly in position\n", test.fen)
+		}
+		unapply()
+		if original := ParseFenVariant(test.fen, Atomic); b != original {
+			t.Error("Move", test.move, "did not unapply cleanly in position\n", test.fen)
+		}
+	}
+}
+
+func TestThreeCheckApply(t *testing.T) {
+	b := ParseFenVariant("rnbqkbnr/ppppp1pp/8/5p2/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 +0+0", ThreeCheck)
+	unapply := b.Apply(parseMove("d1h5"))
+	if b.RemainingChecks(true) != 2 || b.RemainingChecks(false) != 3 {
+		t.Error("Giving check did not count towards Three-check")
+	}
+	if b.Hash() != recomputeBoardHash(&b) {
+		t.Error("Giving check updated the hash incorrectly")
+	}
+	unapply()
+	if original := ParseFenVariant("rnbqkbnr/ppppp1pp/8/5p2/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 +0+0", ThreeCheck); b != original {
+		t.Error("Giving check did not unapply cleanly")
+	}
+	// the third check ends the game
+	b = ParseFenVariant("rnbqkbnr/ppppp1pp/8/5p2/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 +2+0", ThreeCheck)
+	b.Apply(parseMove("d1h5"))
+	if b.VariantResult() != WhiteWins || len(b.GenerateLegalMoves()) != 0 {
+		t.Error("The third check should win the game")
+	}
+}
+
+func TestMakeUnmakeMove(t *testing.T) {
+	boards := []Board{
+		ParseFenChess960("1r2k1r1/8/8/8/8/8/8/1R2K1R1 w GBgb - 0 1"),
+		ParseFenVariant("2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1", Crazyhouse),
+		ParseFenVariant("r3k1rR/5K2/8/8/8/8/8/8 b kq - 0 1", Atomic),
+		// captures that explode pieces of both colours
+		ParseFenVariant("r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", Atomic),
+		ParseFenVariant(Startpos+" +2+2", ThreeCheck),
+	}
+	for _, fen := range perftSuitePositions {
+		boards = append(boards, ParseFen(fen))
+	}
+	for _, b := range boards {
+		walkPositions(&b, 2, func(b *Board) {
+			var undo UndoInfo
+			for _, move := range b.GenerateLegalMoves() {
+				before := *b
+				b.MakeMove(move, &undo)
+				if b.Hash() != recomputeBoardHash(b) {
+					t.Error("Making", &move, "updated the hash incorrectly in position\n", before.ToFen())
+				}
+				b.UnmakeMove(move, &undo)
+				if *b != before {
+					t.Error("Move", &move, "did not unmake cleanly in position\n", before.ToFen())
+				}
+			}
+		})
+	}
+}
+
+// Making and unmaking moves must not allocate, unlike Apply, which returns a closure.
+func TestMakeMoveAllocs(t *testing.T) {
+	b := ParseFen(perftSuitePositions[2])
+	allocs := testing.AllocsPerRun(10, func() {
+		Perft(&b, 3)
+	})
+	if allocs != 0 {
+		t.Error("Perft at depth 3 allocated", allocs, "times")
+	}
+}
+
+func TestApplyNull(t *testing.T) {
+	fen := "rnbqkbnr/ppp1pppp/8/8/3pP3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 3"
+	b := ParseFen(fen)
+	unapply, err := b.ApplyNull()
+	if err != nil {
+		t.Fatal("The null move failed:", err)
+	}
+	// the en passant square is gone, and it is white's move again
+	after := ParseFen("rnbqkbnr/ppp1pppp/8/8/3pP3/8/PPPP1PPP/RNBQKBNR w KQkq - 1 4")
+	if b != after || b.Hash() != recomputeBoardHash(&b) {
+		t.Error("The null move gave\n", b.ToFen(), "\ninstead of\n", after.ToFen())
+	}
+	if moves := b.GenerateLegalMoves(); len(moves) != len(after.GenerateLegalMoves()) ||
+		containsMove(moves, parseMove("d4e3")) {
+		t.Error("Incorrect moves after the null move")
+	}
+	unapply()
+	if original := ParseFen(fen); b != original {
+		t.Error("The null move did not unapply cleanly")
+	}
+	b = ParseFen("rnbqkbnr/ppp2ppp/8/1B2p3/4P3/8/PPPP1PPP/RNBQK1NR b KQkq - 1 3")
+	if _, err := b.ApplyNull(); err != ErrNullMoveInCheck {
+		t.Error("The null move in check should fail with ErrNullMoveInCheck, not", err)
+	}
+}
+
+func TestPawnAndMaterialHash(t *testing.T) {
+	boards := []Board{
+		ParseFenChess960("1r2k1r1/8/8/8/8/8/8/1R2K1R1 w GBgb - 0 1"),
+		ParseFenVariant("2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1", Crazyhouse),
+		ParseFenVariant("r3k1rR/5K2/8/8/8/8/8/8 b kq - 0 1", Atomic),
+		ParseFenVariant("8/P7/8/8/8/8/7p/8 w - - 0 1", Antichess),
+	}
+	for _, fen := range perftSuitePositions {
+		boards = append(boards, ParseFen(fen))
+	}
+	for _, b := range boards {
+		walkPositions(&b, 3, func(b *Board) {
+			if b.PawnHash() != recomputePawnHash(b) || b.MaterialHash() != recomputeMaterialHash(b) {
+				t.Error("Incorrect pawn or material hash in position\n", b.ToFen())
+			}
+		})
+	}
+	// the pawn hash only depends on the pawns and kings, and the material hash on the counts
+	b1 := ParseFen("4k3/pp6/8/8/8/8/PP6/R3K3 w - - 0 1")
+	b2 := ParseFen("4k3/pp6/8/8/8/8/PP6/4K2R b - - 0 1")
+	if b1.PawnHash() != b2.PawnHash() || b1.MaterialHash() != b2.MaterialHash() {
+		t.Error("Moving a rook should not change the pawn or material hash")
+	}
+	b2 = ParseFen("4k3/p7/1p6/8/8/8/PP6/R3K3 w - - 0 1")
+	if b1.PawnHash() == b2.PawnHash() || b1.MaterialHash() != b2.MaterialHash() {
+		t.Error("Moving a pawn should only change the pawn hash")
+	}
+	b2 = ParseFen("4k3/pp6/8/8/8/8/PP6/N3K3 w - - 0 1")
+	if b1.PawnHash() != b2.PawnHash() || b1.MaterialHash() == b2.MaterialHash() {
+		t.Error("Changing a piece should only change the material hash")
+	}
+}
@@ -14,6 +14,10 @@ import (
 const nsPerMs = 1000000
 const nsPerS = 1000000000
 
+const kiwipetePos = "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 0"
+const densePos = "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1"
+const endgamePos = "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 0"
+
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 
 func main() {
@@ -33,9 +37,94 @@ func main() {
 	printResultLine(testing.Benchmark(benchmarkKiwipete), "Kiwipete position", kpResult, 5)
 	printResultLine(testing.Benchmark(benchmarkDense), "Dense position", denseResult, 6)
 	printResultLine(testing.Benchmark(benchmarkEndgameRP), "Endgame R/P position", endgameResult, 7)
+	printMovegenLine(testing.Benchmark(benchmarkMovegenKiwipete), "Kiwipete movegen")
+	fmt.Println("\nLEAF COUNTING SPEEDUP (CountLegalMoves vs. generating the leaf moves)")
+	printLeafCountingLine("Start position", dragontoothmg.Startpos, 5)
+	printLeafCountingLine("Kiwipete position", kiwipetePos, 4)
+	printLeafCountingLine("Dense position", densePos, 5)
+	printLeafCountingLine("Endgame R/P position", endgamePos, 6)
+	fmt.Println("\nMAKE/UNMAKE SPEEDUP (MakeMove and UnmakeMove vs. the closure returned by Apply)")
+	printMakeMoveLine("Start position", dragontoothmg.Startpos, 5)
+	printMakeMoveLine("Kiwipete position", kiwipetePos, 4)
+	printMakeMoveLine("Endgame R/P position", endgamePos, 6)
+	fmt.Println("\nCOPY-MAKE (WithMove vs. Apply and unapply, and PerftParallel built on WithMove)")
+	printCopyMakeLine("Start position", dragontoothmg.Startpos, 5)
+	printCopyMakeLine("Kiwipete position", kiwipetePos, 4)
+	printCopyMakeLine("Dense position", densePos, 5)
+	printCopyMakeLine("Endgame R/P position", endgamePos, 6)
 	fmt.Println()
 }
 
+// Compares a perft that copies the board with WithMove against one that uses Apply, and
+// against PerftParallel.
+func printCopyMakeLine(name string, fen string, depth int) {
+	board := dragontoothmg.ParseFen(fen)
+	nodes := dragontoothmg.Perft(&board, depth)
+	nps := func(res testing.BenchmarkResult) float64 {
+		return float64(nodes) / (float64(res.NsPerOp()) / nsPerS)
+	}
+	applied := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			perftApplying(&board, depth)
+		}
+	})
+	copied := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			perftCopyMake(&board, depth)
+		}
+	})
+	parallel := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dragontoothmg.PerftParallel(&board, depth)
+		}
+	})
+	fmt.Printf("%-22s depth %-3d %11.0fnps applied %11.0fnps copied %11.0fnps parallel\n",
+		name + ":", depth, nps(applied), nps(copied), nps(parallel))
+}
+
+// Compares Perft, which uses MakeMove and UnmakeMove, against a perft that uses Apply.
+func printMakeMoveLine(name string, fen string, depth int) {
+	board := dragontoothmg.ParseFen(fen)
+	nodes := dragontoothmg.Perft(&board, depth)
+	applied := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			perftApplying(&board, depth)
+		}
+	})
+	made := testing.Benchmark(func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			dragontoothmg.Perft(&board, depth)
+		}
+	})
+	fmt.Printf("%-22s depth %-3d %11.0fnps %8d allocs/op applied %11.0fnps %8d allocs/op made\n",
+		name + ":", depth, float64(nodes) / (float64(applied.NsPerOp()) / nsPerS), applied.AllocsPerOp(),
+		float64(nodes) / (float64(made.NsPerOp()) / nsPerS), made.AllocsPerOp())
+}
+
+// Compares Perft against a perft that generates every leaf move instead of counting them.
+func printLeafCountingLine(name string, fen string, depth int) {
+	board := dragontoothmg.ParseFen(fen)
+	generated := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			perftGeneratingLeaves(&board, depth)
+		}
+	})
+	counted := testing.Benchmark(func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dragontoothmg.Perft(&board, depth)
+		}
+	})
+	fmt.Printf("%-22s depth %-3d %8dms generated %8dms counted %6.2fx speedup\n", name + ":", depth,
+		generated.NsPerOp() / nsPerMs, counted.NsPerOp() / nsPerMs,
+		float64(generated.NsPerOp()) / float64(counted.NsPerOp()))
+}
+
+func printMovegenLine(res testing.BenchmarkResult, name string) {
+	fmt.Printf("%-22s %20dns/op %10d allocs/op\n", name + ":", res.NsPerOp(), res.AllocsPerOp())
+}
+
 func printResultLine(res testing.BenchmarkResult, name string, perftValue int64, depth int) {
 	fmt.Printf("%-22s depth %-3d %8dms %12d nodes  %11.0fnps\n", name + ":", depth, res.NsPerOp() / nsPerMs,
 		perftValue, float64(perftValue) / (float64(res.NsPerOp()) / nsPerS))
@@ -65,8 +154,7 @@ func benchmarkStartpos6(b *testing.B) {
 
 var kpResult int64 = 0
 func benchmarkKiwipete(b *testing.B) {
-	pos := "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 0"
-	board := dragontoothmg.ParseFen(pos)
+	board := dragontoothmg.ParseFen(kiwipetePos)
 	for i := 0; i < b.N; i++ {
 		kpResult = dragontoothmg.Perft(&board, 5)
 	}
@@ -74,8 +162,7 @@ func benchmarkKiwipete(b *testing.B) {
 
 var denseResult int64 = 0
 func benchmarkDense(b *testing.B) {
-	pos := "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1"
-	board := dragontoothmg.ParseFen(pos)
+	board := dragontoothmg.ParseFen(densePos)
 	for i := 0; i < b.N; i++ {
 		denseResult = dragontoothmg.Perft(&board, 6)
 	}
@@ -83,9 +170,64 @@ func benchmarkDense(b *testing.B) {
 
 var endgameResult int64 = 0
 func benchmarkEndgameRP(b *testing.B) {
-	pos := "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 0"
-	board := dragontoothmg.ParseFen(pos)
+	board := dragontoothmg.ParseFen(endgamePos)
 	for i := 0; i < b.N; i++ {
 		endgameResult = dragontoothmg.Perft(&board, 7)
 	}
 }
+
+func benchmarkMovegenKiwipete(b *testing.B) {
+	board := dragontoothmg.ParseFen(kiwipetePos)
+	var moves dragontoothmg.MoveList
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		board.GenerateLegalMovesInto(&moves)
+	}
+}
+
+// Perft as it was before leaf counting: the moves of the last ply are generated, not counted.
+func perftGeneratingLeaves(b *dragontoothmg.Board, n int) int64 {
+	var moves dragontoothmg.MoveList
+	b.GenerateLegalMovesInto(&moves)
+	if n <= 1 {
+		return int64(moves.Len)
+	}
+	var count int64 = 0
+	for _, move := range moves.Slice() {
+		unapply := b.Apply(move)
+		count += perftGeneratingLeaves(b, n-1)
+		unapply()
+	}
+	return count
+}
+
+// Perft using Apply, which allocates an unapply closure for every move made.
+func perftApplying(b *dragontoothmg.Board, n int) int64 {
+	if n <= 1 {
+		return int64(b.CountLegalMoves())
+	}
+	var moves dragontoothmg.MoveList
+	b.GenerateLegalMovesInto(&moves)
+	var count int64 = 0
+	for _, move := range moves.Slice() {
+		unapply := b.Apply(move)
+		count += perftApplying(b, n-1)
+		unapply()
+	}
+	return count
+}
+
+// Perft using WithMove, which copies the board for every move instead of unapplying it.
+func perftCopyMake(b *dragontoothmg.Board, n int) int64 {
+	if n <= 1 {
+		return int64(b.CountLegalMoves())
+	}
+	var moves dragontoothmg.MoveList
+	b.GenerateLegalMovesInto(&moves)
+	var count int64 = 0
+	for _, move := range moves.Slice() {
+		child := b.WithMove(move)
+		count += perftCopyMake(&child, n-1)
+	}
+	return count
+}
@@ -28,6 +28,19 @@ func generateZobristConstants() {
 	for i := 0; i < 4; i++ {
 		castleRightsZobristC[i] = rand.Uint64()
 	}
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 5; j++ {
+			for k := 0; k < kMaxPocketCount; k++ {
+				pocketZobristC[i][j][k] = rand.Uint64()
+			}
+		}
+		for j := 0; j < kChecksToWin; j++ {
+			checksZobristC[i][j] = rand.Uint64()
+		}
+	}
+	for i := 0; i < 12; i++ {
+		materialZobristC[i] = rand.Uint64()
+	}
 }
 
 func generateRookMagicTable() {
@@ -173,13 +186,44 @@ func bishopMovesFromBlockers(origin Square, blockers uint64) uint64 {
 // The starting position FEN
 const Startpos = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
 
+// The starting position FEN for Racing Kings
+const RacingKingsStartpos = "8/8/8/8/8/8/krbnNBRK/qrbnNBRQ w - - 0 1"
+
+// The starting position FEN for Horde, where white has 36 pawns and no king
+const HordeStartpos = "rnbqkbnr/pppppppp/8/1PP2PP1/PPPPPPPP/PPPPPPPP/PPPPPPPP/PPPPPPPP w kq - 0 1"
+
 // Zobrist Constants
 var pieceSquareZobristC [12][64]uint64
 var castleRightsZobristC [4]uint64
 var whiteToMoveZobristC uint64 // active if white is to move
+// For each side and piece type, the key for having more than k pieces in the pocket
+var pocketZobristC [2][5][kMaxPocketCount]uint64
+
+// The most pieces of one type that a Crazyhouse pocket can hold: all 16 pawns. ParseFenVariant
+// rejects positions with more pieces of a type on the board and in the pockets.
+const kMaxPocketCount = 16
+
+// For each piece, the key that is added to the material hash for every piece of its type
+var materialZobristC [12]uint64
+
+// For each side, the key for having given more than k checks
+var checksZobristC [2][kChecksToWin]uint64
+
+// The number of checks that wins a Three-check game.
+const kChecksToWin = 3
+
+// The center squares, d4, e4, d5 and e5, which a king must reach to win King of the Hill
+const centerSquares uint64 = 0x0000001818000000
+
+// The dark squares, including a1 and h8
+const darkSquares uint64 = 0xAA55AA55AA55AA55
 
 const kDefaultMoveListLength int = 65
 
+// The capacity of a MoveList. No chess position has more than 218 legal moves, but
+// Crazyhouse positions can have many more, because of drops.
+const kMaxMoveListLength int = 512
+
 // Bitboard where every bit is active
 var everything uint64 = ^(uint64(0))
 
@@ -0,0 +1,191 @@
+package dragontoothmg
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// Errors returned by Game.Pop.
+var ErrNoMoves = errors.New("There are no moves to take back.")
+
+// How a game ended.
+type Termination uint8
+
+const (
+	Ongoing              Termination = iota // the game is not over
+	Checkmate                               // the side to move is checkmated
+	Stalemate                               // the side to move has no legal moves, but is not in check
+	VariantEnd                              // the game ended by a rule of its variant
+	InsufficientMaterial                    // neither side can checkmate
+	FivefoldRepetition                      // the position occurred five times
+	SeventyFiveMoves                        // 75 moves by each side without a capture or pawn move
+	ThreefoldRepetition                     // the position occurred three times, so a draw can be claimed
+	FiftyMoves                              // 50 moves by each side without a capture or pawn move, so a draw can be claimed
+)
+
+// The outcome of a game: how it ended, and which side won.
+type Outcome struct {
+	Termination Termination
+	Result      Result
+}
+
+// A game of chess: a board, with the moves that led to it. Moves can be pushed and popped,
+// and the game detects repetitions and other draws, which a Board alone can't.
+type Game struct {
+	board  Board
+	moves  []Move
+	undos  []UndoInfo
+	hashes []uint64 // the hash of the position before each move
+}
+
+// Creates a game starting from the position on b.
+func NewGame(b Board) Game {
+	return Game{board: b}
+}
+
+// Returns a copy of the current position.
+func (g *Game) Board() Board {
+	return g.board
+}
+
+// Returns the moves played so far, in order.
+func (g *Game) Moves() []Move {
+	moves := make([]Move, len(g.moves))
+	copy(moves, g.moves)
+	return moves
+}
+
+// Plays a move, after checking that it is legal. If it is illegal, the game is unchanged,
+// and an error describing the problem is returned, as for ApplyChecked.
+func (g *Game) Push(m Move) error {
+	if err := g.board.checkLegal(m); err != nil {
+		return err
+	}
+	g.hashes = append(g.hashes, g.board.repetitionKey())
+	g.moves = append(g.moves, m)
+	g.undos = append(g.undos, UndoInfo{})
+	g.board.MakeMove(m, &g.undos[len(g.undos)-1])
+	return nil
+}
+
+// Takes back the last move, and returns it.
+func (g *Game) Pop() (Move, error) {
+	last := len(g.moves) - 1
+	if last < 0 {
+		return 0, ErrNoMoves
+	}
+	m := g.moves[last]
+	g.board.UnmakeMove(m, &g.undos[last])
+	g.moves, g.undos, g.hashes = g.moves[:last], g.undos[:last], g.hashes[:last]
+	return m, nil
+}
+
+// Counts how many times the current position has occurred in the game, including now.
+// Positions are compared by their hashes. Only the positions since the last capture or pawn
+// move are searched, since no earlier position can repeat.
+func (g *Game) RepetitionCount() int {
+	count := 1
+	hash := g.board.repetitionKey()
+	earliest := len(g.hashes) - int(g.board.Halfmoveclock)
+	for i := len(g.hashes) - 2; i >= 0 && i >= earliest; i -= 2 {
+		if g.hashes[i] == hash {
+			count++
+		}
+	}
+	return count
+}
+
+// Whether the current position has occurred at least three times, so a draw can be claimed.
+func (g *Game) IsThreefoldRepetition() bool {
+	return g.RepetitionCount() >= 3
+}
+
+// Whether the current position has occurred at least five times, which ends the game in a draw.
+func (g *Game) IsFivefoldRepetition() bool {
+	return g.RepetitionCount() >= 5
+}
+
+// Returns the outcome of the game, or Ongoing with NoResult if it is not over.
+// Draws that must be claimed (threefold repetition and the 50-move rule) are reported too,
+// after the outcomes that end the game on their own.
+func (g *Game) Outcome() Outcome {
+	b := &g.board
+	if b.variant != Standard {
+		if result := b.VariantResult(); result != NoResult {
+			return Outcome{VariantEnd, result}
+		}
+	}
+	if b.CountLegalMoves() == 0 {
+		if !b.OurKingInCheck() {
+			return Outcome{Stalemate, Draw}
+		} else if b.Wtomove {
+			return Outcome{Checkmate, BlackWins}
+		}
+		return Outcome{Checkmate, WhiteWins}
+	}
+	if b.variant == Standard && b.InsufficientMaterial() {
+		return Outcome{InsufficientMaterial, Draw}
+	}
+	repetitions := g.RepetitionCount()
+	switch {
+	case repetitions >= 5:
+		return Outcome{FivefoldRepetition, Draw}
+	case b.Halfmoveclock >= 150:
+		return Outcome{SeventyFiveMoves, Draw}
+	case repetitions >= 3:
+		return Outcome{ThreefoldRepetition, Draw}
+	case b.Halfmoveclock >= 100:
+		return Outcome{FiftyMoves, Draw}
+	}
+	return Outcome{Ongoing, NoResult}
+}
+
+// The hash of the position, for detecting repetitions. An en passant square only makes a
+// position different if an en passant capture is legal.
+func (b *Board) repetitionKey() uint64 {
+	if b.enpassant == 0 {
+		return b.hash
+	}
+	enpassantBB := uint64(1) << b.enpassant
+	capturers := b.Black.Pawns & (enpassantBB<<7&^onlyFile[7] | enpassantBB<<9&^onlyFile[0])
+	if b.Wtomove {
+		capturers = b.White.Pawns & (enpassantBB>>9&^onlyFile[7] | enpassantBB>>7&^onlyFile[0])
+	}
+	for ; capturers != 0; capturers &= capturers - 1 {
+		var m Move
+		m.Setfrom(Square(bits.TrailingZeros64(capturers))).Setto(Square(b.enpassant))
+		if b.IsLegal(m) {
+			return b.hash
+		}
+	}
+	return b.hash ^ uint64(b.enpassant)
+}
+
+// Whether neither side can possibly checkmate, by any sequence of legal moves, so the game
+// is drawn. This follows the rules of standard chess.
+func (b *Board) InsufficientMaterial() bool {
+	return !b.CanWin(true) && !b.CanWin(false)
+}
+
+// Whether a side has enough material to checkmate, if its opponent plays badly enough.
+// A lone king can't; neither can a king and knight, unless the opponent has pieces to block
+// its own king with; nor can kings and bishops that are all on squares of one colour.
+func (b *Board) CanWin(white bool) bool {
+	ours, theirs := &(b.Black), &(b.White)
+	if white {
+		ours, theirs = &(b.White), &(b.Black)
+	}
+	if ours.Pawns|ours.Rooks|ours.Queens != 0 {
+		return true
+	}
+	if ours.Knights != 0 { // a single knight can only mate a king hemmed in by its own pieces
+		return bits.OnesCount64(ours.Knights|ours.Bishops) > 1 ||
+			theirs.Pawns|theirs.Knights|theirs.Bishops|theirs.Rooks != 0
+	}
+	if ours.Bishops != 0 { // bishops on one colour can't mate, unless another piece blocks
+		bishops := b.White.Bishops | b.Black.Bishops
+		sameColour := bishops&darkSquares == 0 || bishops&^darkSquares == 0
+		return !sameColour || b.White.Pawns|b.Black.Pawns|b.White.Knights|b.Black.Knights != 0
+	}
+	return false
+}
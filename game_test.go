@@ -0,0 +1,139 @@
+package dragontoothmg
+
+import (
+	"testing"
+)
+
+// Pushes the moves, given as strings, failing the test if any is illegal.
+func pushMoves(g *Game, moves []string, t *testing.T) {
+	for _, move := range moves {
+		if err := g.Push(parseMove(move)); err != nil {
+			t.Fatal("Could not push", move, ":", err)
+		}
+	}
+}
+
+func TestGamePushPop(t *testing.T) {
+	g := NewGame(ParseFen(Startpos))
+	pushMoves(&g, []string{"e2e4", "e7e5", "g1f3"}, t)
+	if len(g.Moves()) != 3 || g.Moves()[2] != parseMove("g1f3") {
+		t.Error("Incorrect move history", g.Moves())
+	}
+	if err := g.Push(parseMove("e1e2")); err != ErrWrongSide {
+		t.Error("Pushing an illegal move should fail with ErrWrongSide, not", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := g.Pop(); err != nil {
+			t.Error("Could not pop move", i, ":", err)
+		}
+	}
+	if b := g.Board(); b != ParseFen(Startpos) {
+		t.Error("Popping every move did not restore the starting position, but gave\n", b.ToFen())
+	}
+	if _, err := g.Pop(); err != ErrNoMoves {
+		t.Error("Popping without moves should fail with ErrNoMoves, not", err)
+	}
+}
+
+func TestGameRepetition(t *testing.T) {
+	shuffle := []string{"g1f3", "g8f6", "f3g1", "f6g8"}
+	g := NewGame(ParseFen(Startpos))
+	for i := 1; i <= 5; i++ {
+		if g.RepetitionCount() != i {
+			t.Error("The starting position should have occurred", i, "times, not", g.RepetitionCount())
+		}
+		outcome := g.Outcome()
+		switch {
+		case i >= 5 && outcome != Outcome{FivefoldRepetition, Draw}:
+			t.Error("Expected a fivefold repetition, but got", outcome)
+		case i >= 3 && i < 5 && outcome != Outcome{ThreefoldRepetition, Draw}:
+			t.Error("Expected a threefold repetition, but got", outcome)
+		case i < 3 && outcome != Outcome{Ongoing, NoResult}:
+			t.Error("Expected the game to be ongoing, but got", outcome)
+		}
+		if i < 5 {
+			pushMoves(&g, shuffle, t)
+		}
+	}
+	// positions before a pawn move can't repeat
+	g = NewGame(ParseFen(Startpos))
+	pushMoves(&g, shuffle, t)
+	pushMoves(&g, []string{"e2e4", "e7e5"}, t)
+	pushMoves(&g, shuffle, t)
+	pushMoves(&g, shuffle, t)
+	if g.RepetitionCount() != 3 || !g.IsThreefoldRepetition() || g.IsFivefoldRepetition() {
+		t.Error("The position after e4 e5 should have occurred 3 times, not", g.RepetitionCount())
+	}
+}
+
+func TestGameOutcome(t *testing.T) {
+	tests := []struct {
+		fen     string
+		moves   []string
+		outcome Outcome
+	}{
+		{Startpos, nil, Outcome{Ongoing, NoResult}},
+		{Startpos, []string{"f2f3", "e7e5", "g2g4", "d8h4"}, Outcome{Checkmate, BlackWins}},
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", []string{"a1a8"}, Outcome{Checkmate, WhiteWins}},
+		{"7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", nil, Outcome{Stalemate, Draw}},
+		{"8/8/4k3/8/8/3NK3/8/8 w - - 0 1", nil, Outcome{InsufficientMaterial, Draw}},
+		{"8/8/4k3/8/8/4K3/8/8 w - - 0 1", nil, Outcome{InsufficientMaterial, Draw}},
+		{"8/8/4k3/8/8/3RK3/8/8 w - - 0 1", nil, Outcome{Ongoing, NoResult}},
+		{"8/8/4k3/8/8/3RK3/8/8 w - - 99 80", []string{"d3d4"}, Outcome{FiftyMoves, Draw}},
+		{"8/8/4k3/8/8/3RK3/8/8 w - - 149 80", []string{"d3d4"}, Outcome{SeventyFiveMoves, Draw}},
+		// a checkmate on the 100th halfmove still counts
+		{"6k1/5ppp/8/8/8/8/8/R5K1 w - - 99 80", []string{"a1a8"}, Outcome{Checkmate, WhiteWins}},
+	}
+	for _, test := range tests {
+		g := NewGame(ParseFen(test.fen))
+		pushMoves(&g, test.moves, t)
+		if outcome := g.Outcome(); outcome != test.outcome {
+			t.Error("Expected outcome", test.outcome, "but got", outcome, "for position\n", test.fen,
+				"\nafter moves", test.moves)
+		}
+	}
+	g := NewGame(ParseFenVariant("4k3/8/8/8/3K4/8/8/8 b - - 0 1", KingOfTheHill))
+	if outcome := g.Outcome(); outcome != (Outcome{VariantEnd, WhiteWins}) {
+		t.Error("Expected a King of the Hill win, but got", outcome)
+	}
+}
+
+func TestInsufficientMaterial(t *testing.T) {
+	tests := []struct {
+		fen                      string
+		whiteCanWin, blackCanWin bool
+	}{
+		{Startpos, true, true},
+		{"8/8/4k3/8/8/4K3/8/8 w - - 0 1", false, false},
+		{"8/8/4k3/8/8/3NK3/8/8 w - - 0 1", false, false},
+		{"8/8/4k3/8/8/3BK3/8/8 w - - 0 1", false, false},
+		{"8/8/4k3/8/8/3PK3/8/8 w - - 0 1", true, false},
+		{"8/8/4k3/8/8/3RK3/8/8 w - - 0 1", true, false},
+		{"8/8/4k3/8/8/3QK3/8/8 b - - 0 1", true, false},
+		// two knights can't force mate, but can mate a king that blunders
+		{"8/8/4k3/8/8/2NNK3/8/8 w - - 0 1", true, false},
+		{"8/8/4k3/8/8/2BNK3/8/8 w - - 0 1", true, false},
+		// a knight can mate a king hemmed in by its own pieces
+		{"8/8/3nk3/8/8/3NK3/8/8 w - - 0 1", true, true},
+		{"8/8/3rk3/8/8/3NK3/8/8 w - - 0 1", true, true},
+		{"8/8/3qk3/8/8/3NK3/8/8 w - - 0 1", false, true},
+		// bishops all on squares of one colour can never mate
+		{"8/8/4k3/8/8/2B1K3/8/8 w - - 0 1", false, false},
+		{"8/8/1b2k3/8/8/2B1K3/8/8 w - - 0 1", false, false},
+		{"B7/1B6/2B1k3/8/8/4K3/8/8 w - - 0 1", false, false},
+		{"8/8/2b1k3/8/8/2B1K3/8/8 w - - 0 1", true, true},
+		{"8/8/4k3/8/8/1BB1K3/8/8 w - - 0 1", true, false},
+		{"8/8/4k3/8/8/2B1K3/7p/8 w - - 0 1", true, true},
+		{"8/8/3nk3/8/8/2B1K3/8/8 w - - 0 1", true, true},
+	}
+	for _, test := range tests {
+		b := ParseFen(test.fen)
+		if b.CanWin(true) != test.whiteCanWin || b.CanWin(false) != test.blackCanWin {
+			t.Error("Expected CanWin to be", test.whiteCanWin, "for white and", test.blackCanWin,
+				"for black in position\n", test.fen)
+		}
+		if b.InsufficientMaterial() != (!test.whiteCanWin && !test.blackCanWin) {
+			t.Error("Incorrect InsufficientMaterial in position\n", test.fen)
+		}
+	}
+}
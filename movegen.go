@@ -12,61 +12,550 @@ import (
 )
 
 // The main API entrypoint. Generates all legal moves for a given board.
+// Move generation never modifies the board, so any number of goroutines may generate
+// moves from the same board at once, as long as none of them applies a move to it.
 func (b *Board) GenerateLegalMoves() []Move {
-	moves := make([]Move, 0, kDefaultMoveListLength)
+	return b.generateLegalMoveSlice(genAll, everything)
+}
+
+// Generates all legal moves into a caller-owned list, replacing its contents.
+// Unlike GenerateLegalMoves(), this performs no heap allocations.
+func (b *Board) GenerateLegalMovesInto(ml *MoveList) {
+	ml.Len = 0
+	b.generateLegalMoves(ml.sink(), genAll, everything)
+}
+
+// Generates all legal moves, with the moving and captured pieces and the kind of each move
+// filled in. The moves are in the same order as GenerateLegalMoves(), and can be applied
+// with ApplyExt() without looking up any pieces on the board.
+func (b *Board) GenerateLegalExtMoves() []ExtMove {
+	var ml ExtMoveList
+	b.generateLegalMoves(ml.sink(), genAll, everything)
+	moves := make([]ExtMove, ml.Len)
+	copy(moves, ml.Slice())
+	return moves
+}
+
+// Generates all legal extended moves into a caller-owned list, replacing its contents.
+// Unlike GenerateLegalExtMoves(), this performs no heap allocations.
+func (b *Board) GenerateLegalExtMovesInto(ml *ExtMoveList) {
+	ml.Len = 0
+	b.generateLegalMoves(ml.sink(), genAll, everything)
+}
+
+// Generates the legal moves of the piece on a single square. If there is no piece of the
+// side to move on that square, no moves are returned. Useful for highlighting the legal
+// destinations of a piece in a user interface.
+func (b *Board) GenerateLegalMovesFrom(s Square) []Move {
+	return b.generateLegalMoveSlice(genAll, uint64(1)<<s)
+}
+
+// Returns a bitboard of the squares that the piece on the given square can legally move to.
+// Promotions to different pieces on the same square are merged.
+func (b *Board) LegalDestinations(s Square) uint64 {
+	var ml MoveList
+	b.generateLegalMoves(ml.sink(), genAll, uint64(1)<<s)
+	var destinations uint64
+	for _, move := range ml.Slice() {
+		destinations |= uint64(1) << move.To()
+	}
+	return destinations
+}
+
+// Generates only the legal captures, including en passant and capture-promotions.
+// Pawn pushes that promote to a queen are also included, since they change the material
+// balance. Useful for quiescence search.
+func (b *Board) GenerateLegalCaptures() []Move {
+	return b.generateLegalMoveSlice(genCaptures, everything)
+}
+
+// Generates all legal moves that are not returned by GenerateLegalCaptures(): non-capturing
+// moves, castling, and pawn pushes that underpromote.
+func (b *Board) GenerateLegalQuiets() []Move {
+	return b.generateLegalMoveSlice(genQuiets, everything)
+}
+
+// Generates the legal non-capturing moves that give check, either directly or by discovery.
+// Like GenerateLegalQuiets(), this excludes queen promotions. Useful for quiescence search
+// and mate finders.
+func (b *Board) GenerateQuietChecks() []Move {
+	var moves MoveList
+	b.generateLegalMoves(moves.sink(), genQuiets, everything)
+	ci := b.computeCheckInfo()
+	checks := make([]Move, 0, kDefaultMoveListLength)
+	for _, move := range moves.Slice() {
+		if b.givesCheck(move, &ci) {
+			checks = append(checks, move)
+		}
+	}
+	return checks
+}
+
+// Generates the legal moves selected by mode into a newly allocated slice.
+func (b *Board) generateLegalMoveSlice(mode genMode, movable uint64) []Move {
+	var ml MoveList
+	b.generateLegalMoves(ml.sink(), mode, movable)
+	moves := make([]Move, ml.Len)
+	copy(moves, ml.Slice())
+	return moves
+}
+
+// Generates the legal moves in the categories selected by mode, adding them to moves.
+// Only the pieces on squares in movable are moved.
+func (b *Board) generateLegalMoves(moves *moveSink, mode genMode, movable uint64) {
+	if b.variant != Standard && b.variantRuleResult() != NoResult { // the game is over
+		return
+	}
+	if b.variant == Atomic {
+		b.generateAtomicMoves(moves, mode, movable)
+		return
+	} else if b.variant == Antichess {
+		b.generateAntichessMoves(moves, mode, movable)
+		return
+	} else if b.variant == RacingKings {
+		b.generateRacingKingsMoves(moves, mode, movable)
+		return
+	}
+	b.generateChessMoves(moves, mode, movable)
+}
+
+// Generates the legal moves by the rules of chess, in the categories selected by mode.
+// Only the pieces on squares in movable are moved.
+func (b *Board) generateChessMoves(moves *moveSink, mode genMode, movable uint64) {
 	// First, see if we are currently in check. If we are, invoke a special check-
 	// evasion move generator.
 	var kingLocation uint8
-	var ourPiecesPtr *Bitboards
+	var ourPiecesPtr, oppPiecesPtr *Bitboards
 	if b.Wtomove { // assumes only one king
 		kingLocation = uint8(bits.TrailingZeros64(b.White.Kings))
 		ourPiecesPtr = &(b.White)
+		oppPiecesPtr = &(b.Black)
 	} else {
 		kingLocation = uint8(bits.TrailingZeros64(b.Black.Kings))
 		ourPiecesPtr = &(b.Black)
+		oppPiecesPtr = &(b.White)
+	}
+	// Restrict the destination squares to the requested kind of move.
+	// Pawn pushes and en passant don't fit this mask, so they are handled separately.
+	targetMask := everything
+	if mode == genCaptures {
+		targetMask = oppPiecesPtr.All
+	} else if mode == genQuiets {
+		targetMask = ^oppPiecesPtr.All
+	}
+	kingMovable := ourPiecesPtr.Kings&movable != 0
+	kingAttackers, blockerDestinations := 0, uint64(0)
+	if ourPiecesPtr.Kings != 0 { // without a king, there are no checks or pins
+		kingAttackers, blockerDestinations = b.countAttacks(b.Wtomove, kingLocation, 2)
 	}
-	kingAttackers, blockerDestinations := b.countAttacks(b.Wtomove, kingLocation, 2)
 	if kingAttackers >= 2 { // Under multiple attack, we must move the king.
-		b.kingPushes(&moves, ourPiecesPtr)
-		return moves
+		if kingMovable {
+			b.kingPushes(moves, ourPiecesPtr, targetMask)
+		}
+		return
 	}
 
 	// Several move types can work in single check, but we must block the check
+	allowDest := everything
 	if kingAttackers == 1 {
-		// calculate pinned pieces
-		pinnedPieces := b.generatePinnedMoves(&moves, blockerDestinations)
-		nonpinnedPieces := ^pinnedPieces
-		// TODO
-		b.pawnPushes(&moves, nonpinnedPieces, blockerDestinations)
-		b.pawnCaptures(&moves, nonpinnedPieces, blockerDestinations)
-		b.knightMoves(&moves, nonpinnedPieces, blockerDestinations)
-		b.rookMoves(&moves, nonpinnedPieces, blockerDestinations)
-		b.bishopMoves(&moves, nonpinnedPieces, blockerDestinations)
-		b.queenMoves(&moves, nonpinnedPieces, blockerDestinations)
-		b.kingPushes(&moves, ourPiecesPtr)
-		return moves
+		allowDest = blockerDestinations
 	}
 
 	// Then, calculate all the absolutely pinned pieces, and compute their moves.
 	// If we are in check, we can only move to squares that block the check.
-	pinnedPieces := b.generatePinnedMoves(&moves, everything)
-	nonpinnedPieces := ^pinnedPieces
+	var pinnedPieces uint64
+	enPassant := mode&genCaptures != 0
+	if movable == everything {
+		pinnedPieces = b.generatePinnedMoves(moves, allowDest&targetMask, enPassant)
+	} else {
+		pinnedPieces = b.generatePinnedMovesFrom(moves, allowDest&targetMask, enPassant, movable)
+	}
+	nonpinnedPieces := ^pinnedPieces & movable
 
 	// Finally, compute ordinary moves, ignoring absolutely pinned pieces on the board.
-	b.pawnPushes(&moves, nonpinnedPieces, everything)
-	b.pawnCaptures(&moves, nonpinnedPieces, everything)
-	b.knightMoves(&moves, nonpinnedPieces, everything)
-	b.rookMoves(&moves, nonpinnedPieces, everything)
-	b.bishopMoves(&moves, nonpinnedPieces, everything)
-	b.queenMoves(&moves, nonpinnedPieces, everything)
-	b.kingMoves(&moves)
+	switch mode {
+	case genAll:
+		b.pawnPushes(moves, nonpinnedPieces, allowDest)
+	case genCaptures:
+		b.pawnPushPromotions(moves, nonpinnedPieces, allowDest, Queen, Queen)
+	case genQuiets:
+		b.pawnPushes(moves, nonpinnedPieces, allowDest&^(onlyRank[0]|onlyRank[7]))
+		b.pawnPushPromotions(moves, nonpinnedPieces, allowDest, Knight, Rook)
+	}
+	if mode&genCaptures != 0 {
+		b.pawnCaptures(moves, nonpinnedPieces, allowDest, true)
+	}
+	b.knightMoves(moves, nonpinnedPieces, allowDest&targetMask)
+	b.rookMoves(moves, nonpinnedPieces, allowDest&targetMask)
+	b.bishopMoves(moves, nonpinnedPieces, allowDest&targetMask)
+	b.queenMoves(moves, nonpinnedPieces, allowDest&targetMask)
+	if b.variant == Crazyhouse && mode&genQuiets != 0 && movable == everything {
+		b.dropMoves(moves, allowDest)
+	}
+	if !kingMovable {
+		return
+	}
+	if kingAttackers == 1 { // no castling out of check
+		b.kingPushes(moves, ourPiecesPtr, targetMask)
+	} else {
+		b.kingMoves(moves, targetMask)
+	}
+}
+
+// Counts the legal moves for a given board, without generating them.
+// Equivalent to len(b.GenerateLegalMoves()), but much faster, since most moves are
+// counted with popcounts of their target bitboards.
+func (b *Board) CountLegalMoves() int {
+	if b.variant != Standard && b.variantRuleResult() != NoResult { // the game is over
+		return 0
+	}
+	if b.variant == Atomic || b.variant == Antichess || b.variant == RacingKings { // these are rarely counted
+		var ml MoveList
+		b.generateLegalMoves(ml.sink(), genAll, everything)
+		return ml.Len
+	}
+	var kingLocation uint8
+	var ourPiecesPtr *Bitboards
+	var promotionRank uint64
+	if b.Wtomove { // assumes only one king
+		kingLocation = uint8(bits.TrailingZeros64(b.White.Kings))
+		ourPiecesPtr = &(b.White)
+		promotionRank = onlyRank[7]
+	} else {
+		kingLocation = uint8(bits.TrailingZeros64(b.Black.Kings))
+		ourPiecesPtr = &(b.Black)
+		promotionRank = onlyRank[0]
+	}
+	kingAttackers, blockerDestinations := 0, uint64(0)
+	if ourPiecesPtr.Kings != 0 { // without a king, there are no checks or pins
+		kingAttackers, blockerDestinations = b.countAttacks(b.Wtomove, kingLocation, 2)
+	}
+	if kingAttackers >= 2 { // Under multiple attack, we must move the king.
+		return bits.OnesCount64(b.kingPushTargets(ourPiecesPtr, everything))
+	}
+	allowDest := everything
+	if kingAttackers == 1 {
+		allowDest = blockerDestinations
+	}
+	pinnedPieces, count := b.pinnedMoves(nil, allowDest, true)
+	nonpinnedPieces := ^pinnedPieces
+
+	// Each pawn move to the last rank is four promotions.
+	pushes, doublePushes := b.pawnPushBitboards(nonpinnedPieces)
+	pushes &= allowDest
+	count += bits.OnesCount64(pushes) + 3*bits.OnesCount64(pushes&promotionRank) +
+		bits.OnesCount64(doublePushes&allowDest)
+	east, west := b.pawnCaptureBitboards(nonpinnedPieces)
+	if b.enpassant != 0 { // en passant is always allowed, but must be checked for legality
+		enpassantBB := uint64(1) << b.enpassant
+		eastOrigin, westOrigin := b.enpassant-9, b.enpassant-7
+		if !b.Wtomove {
+			eastOrigin, westOrigin = b.enpassant+7, b.enpassant+9
+		}
+		if east&enpassantBB != 0 && b.enPassantIsLegal(eastOrigin) {
+			count++
+		}
+		if west&enpassantBB != 0 && b.enPassantIsLegal(westOrigin) {
+			count++
+		}
+		east, west = east&^enpassantBB, west&^enpassantBB
+	}
+	east, west = east&allowDest, west&allowDest
+	count += bits.OnesCount64(east) + 3*bits.OnesCount64(east&promotionRank) +
+		bits.OnesCount64(west) + 3*bits.OnesCount64(west&promotionRank)
+
+	allPieces := b.White.All | b.Black.All
+	pieceDest := ^ourPiecesPtr.All & allowDest
+	for knights := ourPiecesPtr.Knights & nonpinnedPieces; knights != 0; knights &= knights - 1 {
+		count += bits.OnesCount64(knightMasks[bits.TrailingZeros64(knights)] & pieceDest)
+	}
+	for diagonals := (ourPiecesPtr.Bishops | ourPiecesPtr.Queens) & nonpinnedPieces; diagonals != 0; diagonals &= diagonals - 1 {
+		origin := uint8(bits.TrailingZeros64(diagonals))
+		count += bits.OnesCount64(CalculateBishopMoveBitboard(origin, allPieces) & pieceDest)
+	}
+	for orthogonals := (ourPiecesPtr.Rooks | ourPiecesPtr.Queens) & nonpinnedPieces; orthogonals != 0; orthogonals &= orthogonals - 1 {
+		origin := uint8(bits.TrailingZeros64(orthogonals))
+		count += bits.OnesCount64(CalculateRookMoveBitboard(origin, allPieces) & pieceDest)
+	}
+
+	if b.variant == Crazyhouse {
+		pocket := &b.pockets[sideIndex(b.Wtomove)]
+		for p := Piece(Pawn); p <= Queen; p++ {
+			if pocket[p-1] != 0 {
+				count += bits.OnesCount64(b.dropTargets(p, allowDest))
+			}
+		}
+	}
+
+	count += bits.OnesCount64(b.kingPushTargets(ourPiecesPtr, everything))
+	if kingAttackers == 0 { // no castling out of check
+		kingside, queenside := b.castlingAvailable()
+		if kingside {
+			count++
+		}
+		if queenside {
+			count++
+		}
+	}
+	return count
+}
+
+// Generates all pseudo-legal moves: moves that follow the movement rules of each piece,
+// but might leave our king in check. Castling moves are included if we have the castling
+// rights and the path between the king and rook is clear. The legality of each move can be
+// checked with IsLegal(), which is useful if most moves are expected to be pruned.
+func (b *Board) GeneratePseudoLegalMoves() []Move {
+	var ml MoveList
+	b.GeneratePseudoLegalMovesInto(&ml)
+	moves := make([]Move, ml.Len)
+	copy(moves, ml.Slice())
 	return moves
 }
 
+// Generates all pseudo-legal moves into a caller-owned list, replacing its contents.
+func (b *Board) GeneratePseudoLegalMovesInto(ml *MoveList) {
+	ml.Len = 0
+	b.generatePseudoLegalMoves(ml.sink())
+}
+
+// Generates all pseudo-legal moves, adding them to ml.
+func (b *Board) generatePseudoLegalMoves(ml *moveSink) {
+	b.pawnPushes(ml, everything, everything)
+	b.pawnCaptures(ml, everything, everything, false)
+	b.knightMoves(ml, everything, everything)
+	b.rookMoves(ml, everything, everything)
+	b.bishopMoves(ml, everything, everything)
+	b.queenMoves(ml, everything, everything)
+	if b.variant == Crazyhouse {
+		b.dropMoves(ml, everything)
+	}
+	var ourKings, ourPieces uint64
+	if b.Wtomove {
+		ourKings, ourPieces = b.White.Kings, b.White.All
+	} else {
+		ourKings, ourPieces = b.Black.Kings, b.Black.All
+	}
+	if b.variant == Atomic { // kings can't capture, since they would explode
+		ourPieces = b.White.All | b.Black.All
+	}
+	for ourKings != 0 {
+		currKing := uint8(bits.TrailingZeros64(ourKings))
+		ourKings &= ourKings - 1
+		b.genMovesFromTargets(ml, King, Square(currKing), kingMasks[currKing]&^ourPieces)
+		kingside, queenside := b.castlingPathsClear()
+		if kingside {
+			ml.add(castleMove(b.castlingMove(true)))
+		}
+		if queenside {
+			ml.add(castleMove(b.castlingMove(false)))
+		}
+	}
+}
+
+// Determines whether a move is pseudo-legal in the current position: it moves one of our
+// pieces according to its movement rules, but it might leave our king in check.
+// Any 16-bit Move may be tested, which is useful for validating moves from a hash table.
+func (b *Board) IsPseudoLegal(m Move) bool {
+	return b.checkPseudoLegal(m) == nil
+}
+
+// Determines whether a move is legal in the current position, without generating the list
+// of legal moves. Any 16-bit Move may be tested, which is useful for validating hash moves
+// and killer moves.
+func (b *Board) IsLegal(m Move) bool {
+	return b.checkLegal(m) == nil
+}
+
+// Returns nil if the move is pseudo-legal, or otherwise an error describing the problem.
+func (b *Board) checkPseudoLegal(m Move) error {
+	if m&0x8000 != 0 {
+		return b.checkDrop(m)
+	}
+	var ourPieces, oppPieces *Bitboards
+	var ourPromotionRank uint64
+	if b.Wtomove {
+		ourPieces, oppPieces = &(b.White), &(b.Black)
+		ourPromotionRank = onlyRank[7]
+	} else {
+		ourPieces, oppPieces = &(b.Black), &(b.White)
+		ourPromotionRank = onlyRank[0]
+	}
+	fromBitboard := uint64(1) << m.From()
+	toBitboard := uint64(1) << m.To()
+	if ourPieces.All&fromBitboard == 0 {
+		if oppPieces.All&fromBitboard != 0 {
+			return ErrWrongSide
+		}
+		return ErrNoPieceOnSource
+	}
+	// In Chess960, castling is encoded as the king capturing its own rook
+	castling960 := b.chess960 && ourPieces.Kings&fromBitboard != 0 && ourPieces.Rooks&toBitboard != 0
+	if ourPieces.All&toBitboard != 0 && !castling960 {
+		return ErrOwnPieceOnDestination
+	}
+	pieceType, _ := determinePieceType(ourPieces, fromBitboard)
+	if pieceType != Pawn && m.Promote() != Nothing {
+		return ErrBadPromotion
+	}
+	allPieces := b.White.All | b.Black.All
+	var targets uint64
+	switch pieceType {
+	case Pawn:
+		// Promotion is required on the last rank, and forbidden elsewhere
+		if toBitboard&ourPromotionRank != 0 {
+			if m.Promote() < Knight || m.Promote() > b.maxPromotion() {
+				return ErrBadPromotion
+			}
+		} else if m.Promote() != Nothing {
+			return ErrBadPromotion
+		}
+		pushTargets, doublePushTargets := b.pawnPushBitboards(fromBitboard)
+		east, west := b.pawnCaptureBitboards(fromBitboard)
+		targets = pushTargets | doublePushTargets | east | west
+	case Knight:
+		targets = knightMasks[m.From()]
+	case Bishop:
+		targets = CalculateBishopMoveBitboard(m.From(), allPieces)
+	case Rook:
+		targets = CalculateRookMoveBitboard(m.From(), allPieces)
+	case Queen:
+		targets = CalculateBishopMoveBitboard(m.From(), allPieces) |
+			CalculateRookMoveBitboard(m.From(), allPieces)
+	case King:
+		targets = kingMasks[m.From()] &^ ourPieces.All
+		if b.variant == Atomic { // kings can't capture, since they would explode
+			targets &^= oppPieces.All
+		}
+		kingside, queenside := b.castlingPathsClear()
+		if kingside {
+			move := b.castlingMove(true)
+			targets |= uint64(1) << move.To()
+		}
+		if queenside {
+			move := b.castlingMove(false)
+			targets |= uint64(1) << move.To()
+		}
+	}
+	if targets&toBitboard == 0 {
+		return ErrIllegalMovement
+	}
+	return nil
+}
+
+// Returns nil if the drop is pseudo-legal, or otherwise an error describing the problem.
+func (b *Board) checkDrop(m Move) error {
+	drop := m.Drop()
+	if b.variant != Crazyhouse || drop < Pawn || drop > Queen || m.From() != 0 {
+		return ErrIllegalMovement
+	}
+	if b.pockets[sideIndex(b.Wtomove)][drop-1] == 0 {
+		return ErrEmptyPocket
+	}
+	if b.dropTargets(drop, everything)&(uint64(1)<<m.To()) == 0 {
+		return ErrIllegalMovement
+	}
+	return nil
+}
+
+// Returns nil if the move is legal, or otherwise an error describing the problem.
+func (b *Board) checkLegal(m Move) error {
+	if b.variant != Standard && b.variantRuleResult() != NoResult {
+		return ErrGameOver
+	}
+	if err := b.checkPseudoLegal(m); err != nil {
+		return err
+	}
+	if m.Drop() != Nothing && b.ourKingSquare() != 64 { // a drop can only fail to block a check
+		attackers, blockerDestinations := b.countAttacks(b.Wtomove, b.ourKingSquare(), 2)
+		if attackers >= 2 || (attackers == 1 && blockerDestinations&(uint64(1)<<m.To()) == 0) {
+			return ErrLeavesKingInCheck
+		}
+		return nil
+	}
+	if b.variant == RacingKings { // no move may give check
+		ci := b.computeCheckInfo()
+		if b.givesCheck(m, &ci) {
+			return ErrGivesCheck
+		}
+	}
+	if b.variant == Antichess { // there is no check, but captures are compulsory
+		if !b.antichessIsCapture(m) && b.antichessCanCapture() {
+			return ErrMustCapture
+		}
+		return nil
+	}
+	if b.variant == Atomic {
+		if !b.atomicMoveIsLegal(m) {
+			return ErrLeavesKingInCheck
+		}
+		return nil
+	}
+	var ourPieces *Bitboards
+	if b.Wtomove {
+		ourPieces = &(b.White)
+	} else {
+		ourPieces = &(b.Black)
+	}
+	fromBitboard := uint64(1) << m.From()
+	toBitboard := uint64(1) << m.To()
+	allPieces := b.White.All | b.Black.All
+	if ourPieces.Kings&fromBitboard != 0 {
+		if rook, isCastle := b.castlingRookFor(m); isCastle {
+			if b.OurKingInCheck() || !b.castlingPathSafe(rook > m.From()) {
+				return ErrCastlingThroughCheck
+			}
+			return nil
+		}
+		// The king must not be attacked on its new square, even by sliders it used to block.
+		if b.underAttackWithOccupancy(b.Wtomove, m.To(), allPieces&^fromBitboard, ^toBitboard) {
+			return ErrLeavesKingInCheck
+		}
+		return nil
+	}
+	if ourPieces.Kings == 0 { // without a king, every pseudo-legal move is legal
+		return nil
+	}
+	ourKingLocation := uint8(bits.TrailingZeros64(ourPieces.Kings))
+	capturedBitboard := toBitboard
+	if ourPieces.Pawns&fromBitboard != 0 && b.enpassant != 0 && m.To() == b.enpassant {
+		if b.Wtomove {
+			capturedBitboard = toBitboard >> 8
+		} else {
+			capturedBitboard = toBitboard << 8
+		}
+	}
+	occupancy := allPieces&^fromBitboard&^capturedBitboard | toBitboard
+	if b.underAttackWithOccupancy(b.Wtomove, ourKingLocation, occupancy, ^capturedBitboard) {
+		return ErrLeavesKingInCheck
+	}
+	return nil
+}
+
 // Calculate the available moves for absolutely pinned pieces (pinned to the king).
-// We are only allowed to move to squares in allowDest, to block checks.
+// We are only allowed to move to squares in allowDest, to block checks. En passant captures
+// along the pin are generated if enPassant is set.
 // Return a bitboard of all pieces that are pinned.
-func (b *Board) generatePinnedMoves(moveList *[]Move, allowDest uint64) uint64 {
+func (b *Board) generatePinnedMoves(moveList *moveSink, allowDest uint64, enPassant bool) uint64 {
+	pinned, _ := b.pinnedMoves(moveList, allowDest, enPassant)
+	return pinned
+}
+
+// Like generatePinnedMoves, but only adds the moves of the pinned pieces on squares in movable.
+func (b *Board) generatePinnedMovesFrom(moveList *moveSink, allowDest uint64, enPassant bool, movable uint64) uint64 {
+	var pinnedMoves ExtMoveList
+	pinned := b.generatePinnedMoves(pinnedMoves.sink(), allowDest, enPassant)
+	for _, move := range pinnedMoves.Slice() {
+		if movable&(uint64(1)<<move.From()) != 0 {
+			moveList.add(move)
+		}
+	}
+	return pinned
+}
+
+// Computes the pinned pieces and the number of moves they have. The moves are added to
+// moveList, unless it is nil.
+func (b *Board) pinnedMoves(moveList *moveSink, allowDest uint64, enPassant bool) (uint64, int) {
+	count := 0
 	var ourKingIdx uint8
 	var ourPieces, oppPieces *Bitboards
 	var allPinnedPieces uint64 = 0
@@ -87,6 +576,9 @@ func (b *Board) generatePinnedMoves(moveList *[]Move, allowDest uint64) uint64 {
 		doublePushRank = onlyRank[4]
 		ourPromotionRank = onlyRank[0]
 	}
+	if ourPieces.Kings == 0 { // nothing is pinned without a king
+		return 0, 0
+	}
 	allPieces := oppPieces.All | ourPieces.All
 
 	// Calculate king moves as if it was a rook.
@@ -116,13 +608,19 @@ func (b *Board) generatePinnedMoves(moveList *[]Move, allowDest uint64) uint64 {
 				if pawnTargets != 0 { // single push worked; try double
 					pawnTargets |= (1 << uint8(int(pinnedPieceIdx)+16*pawnPushDirection)) & ^allPieces & doublePushRank
 				}
-				pawnTargets &= allowDest // TODO this might be a promotion. Is that possible?
-				genMovesFromTargets(moveList, Square(pinnedPieceIdx), pawnTargets)
+				pawnTargets &= allowDest // a pawn pinned on its file can never reach the last rank
+				count += bits.OnesCount64(pawnTargets)
+				if moveList != nil {
+					b.genMovesFromTargets(moveList, Pawn, Square(pinnedPieceIdx), pawnTargets)
+				}
 			}
 			continue
 		}
 		// If it's not a rook or queen, it can't move
-		if pinnedPiece&ourPieces.Rooks == 0 && pinnedPiece&ourPieces.Queens == 0 {
+		pinnedPieceType := Piece(Rook)
+		if pinnedPiece&ourPieces.Queens != 0 {
+			pinnedPieceType = Queen
+		} else if pinnedPiece&ourPieces.Rooks == 0 {
 			continue
 		}
 		// all ortho moves, as if it was not pinned
@@ -130,7 +628,10 @@ func (b *Board) generatePinnedMoves(moveList *[]Move, allowDest uint64) uint64 {
 		// actually available moves
 		pinnedTargets := pinnedPieceAllMoves & (rookTargets | kingOrthoTargets | (uint64(1) << currRookIdx))
 		pinnedTargets &= allowDest
-		genMovesFromTargets(moveList, Square(pinnedPieceIdx), pinnedTargets)
+		count += bits.OnesCount64(pinnedTargets)
+		if moveList != nil {
+			b.genMovesFromTargets(moveList, pinnedPieceType, Square(pinnedPieceIdx), pinnedTargets)
+		}
 	}
 
 	// Calculate king moves as if it was a bishop.
@@ -157,26 +658,50 @@ func (b *Board) generatePinnedMoves(moveList *[]Move, allowDest uint64) uint64 {
 		// if it's a pawn we might be able to capture with it
 		// the capture square must also be in allowdest
 		if pinnedPiece&ourPieces.Pawns != 0 {
+			// an en passant capture can stay on the pin ray, which enPassantIsLegal verifies
+			if east, west := b.pawnCaptureBitboards(pinnedPiece); enPassant && b.enpassant != 0 &&
+				(east|west)&(uint64(1)<<b.enpassant) != 0 && b.enPassantIsLegal(pinnedPieceIdx) {
+				count++
+				if moveList != nil {
+					var move Move
+					move.Setfrom(Square(pinnedPieceIdx)).Setto(Square(b.enpassant))
+					moveList.add(enPassantMove(move))
+				}
+			}
 			if (uint64(1)<<currBishopIdx)&allowDest != 0 {
 				if (b.Wtomove && (pinnedPieceIdx/8)+1 == currBishopIdx/8) ||
 					(!b.Wtomove && pinnedPieceIdx/8 == (currBishopIdx/8)+1) {
-					if ((uint64(1) << currBishopIdx) & ourPromotionRank) != 0 { // We get to promote!
-						for i := Piece(Knight); i <= Queen; i++ {
-							var move Move
-							move.Setfrom(Square(pinnedPieceIdx)).Setto(Square(currBishopIdx)).Setpromote(i)
-							*moveList = append(*moveList, move)
+					if moveList == nil {
+						if ((uint64(1) << currBishopIdx) & ourPromotionRank) != 0 {
+							count += 4
+						} else {
+							count++
+						}
+					} else {
+						captured := Piece(Bishop)
+						if oppPieces.Queens&(uint64(1)<<currBishopIdx) != 0 {
+							captured = Queen
 						}
-					} else { // no promotion
 						var move Move
 						move.Setfrom(Square(pinnedPieceIdx)).Setto(Square(currBishopIdx))
-						*moveList = append(*moveList, move)
+						if ((uint64(1) << currBishopIdx) & ourPromotionRank) != 0 { // We get to promote!
+							for i := Piece(Knight); i <= Queen; i++ {
+								move.Setpromote(i)
+								moveList.addMove(move, Pawn, captured)
+							}
+						} else { // no promotion
+							moveList.addMove(move, Pawn, captured)
+						}
 					}
 				}
 			}
 			continue
 		}
 		// If it's not a bishop or queen, it can't move
-		if pinnedPiece&ourPieces.Bishops == 0 && pinnedPiece&ourPieces.Queens == 0 {
+		pinnedPieceType := Piece(Bishop)
+		if pinnedPiece&ourPieces.Queens != 0 {
+			pinnedPieceType = Queen
+		} else if pinnedPiece&ourPieces.Bishops == 0 {
 			continue
 		}
 		// all diag moves, as if it was not pinned
@@ -184,14 +709,17 @@ func (b *Board) generatePinnedMoves(moveList *[]Move, allowDest uint64) uint64 {
 		// actually available moves
 		pinnedTargets := pinnedPieceAllMoves & (bishopTargets | kingDiagTargets | (uint64(1) << currBishopIdx))
 		pinnedTargets &= allowDest
-		genMovesFromTargets(moveList, Square(pinnedPieceIdx), pinnedTargets)
+		count += bits.OnesCount64(pinnedTargets)
+		if moveList != nil {
+			b.genMovesFromTargets(moveList, pinnedPieceType, Square(pinnedPieceIdx), pinnedTargets)
+		}
 	}
-	return allPinnedPieces
+	return allPinnedPieces, count
 }
 
 // Generate moves involving advancing pawns.
 // Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
-func (b *Board) pawnPushes(moveList *[]Move, nonpinned uint64, allowDest uint64) {
+func (b *Board) pawnPushes(moveList *moveSink, nonpinned uint64, allowDest uint64) {
 	targets, doubleTargets := b.pawnPushBitboards(nonpinned)
 	targets, doubleTargets = targets&allowDest, doubleTargets&allowDest
 	oneRankBack := 8
@@ -211,12 +739,12 @@ func (b *Board) pawnPushes(moveList *[]Move, nonpinned uint64, allowDest uint64)
 		var move Move
 		move.Setfrom(Square(target + oneRankBack)).Setto(Square(target))
 		if canPromote {
-			for i := Piece(Knight); i <= Queen; i++ {
+			for i := Piece(Knight); i <= b.maxPromotion(); i++ {
 				move.Setpromote(i)
-				*moveList = append(*moveList, move)
+				moveList.addMove(move, Pawn, Nothing)
 			}
 		} else {
-			*moveList = append(*moveList, move)
+			moveList.addMove(move, Pawn, Nothing)
 		}
 	}
 	// push some pawns by two squares
@@ -225,7 +753,29 @@ func (b *Board) pawnPushes(moveList *[]Move, nonpinned uint64, allowDest uint64)
 		doubleTargets &= doubleTargets - 1 // unset the lowest active bit
 		var move Move
 		move.Setfrom(Square(doubleTarget + 2*oneRankBack)).Setto(Square(doubleTarget))
-		*moveList = append(*moveList, move)
+		moveList.addMove(move, Pawn, Nothing)
+	}
+}
+
+// Generate only the pawn pushes that promote, to pieces in the range [minPromote, maxPromote].
+// Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
+func (b *Board) pawnPushPromotions(moveList *moveSink, nonpinned uint64, allowDest uint64,
+	minPromote Piece, maxPromote Piece) {
+	targets, _ := b.pawnPushBitboards(nonpinned)
+	targets &= allowDest & (onlyRank[0] | onlyRank[7])
+	oneRankBack := 8
+	if b.Wtomove {
+		oneRankBack = -oneRankBack
+	}
+	for targets != 0 {
+		target := bits.TrailingZeros64(targets)
+		targets &= targets - 1
+		var move Move
+		move.Setfrom(Square(target + oneRankBack)).Setto(Square(target))
+		for i := minPromote; i <= maxPromote; i++ {
+			move.Setpromote(i)
+			moveList.addMove(move, Pawn, Nothing)
+		}
 	}
 }
 
@@ -236,6 +786,9 @@ func (b *Board) pawnPushBitboards(nonpinned uint64) (targets uint64, doubleTarge
 		movableWhitePawns := b.White.Pawns & nonpinned
 		targets = movableWhitePawns << 8 & free
 		doubleTargets = targets << 8 & onlyRank[3] & free
+		if b.variant == Horde { // pawns on the first rank can also move two squares
+			doubleTargets |= targets << 8 & onlyRank[2] & free
+		}
 	} else {
 		movableBlackPawns := b.Black.Pawns & nonpinned
 		targets = movableBlackPawns >> 8 & free
@@ -246,12 +799,17 @@ func (b *Board) pawnPushBitboards(nonpinned uint64) (targets uint64, doubleTarge
 
 // A function that computes available pawn captures.
 // Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
-func (b *Board) pawnCaptures(moveList *[]Move, nonpinned uint64, allowDest uint64) {
+// If legalEnPassant is set, en passant captures that would expose our king are skipped.
+func (b *Board) pawnCaptures(moveList *moveSink, nonpinned uint64, allowDest uint64, legalEnPassant bool) {
 	east, west := b.pawnCaptureBitboards(nonpinned)
 	if b.enpassant > 0 { // always allow us to try en-passant captures
 		allowDest = allowDest | 1<<b.enpassant
 	}
 	east, west = east&allowDest, west&allowDest
+	oppPieces := &(b.White)
+	if b.Wtomove {
+		oppPieces = &(b.Black)
+	}
 	dirbitboards := [2]uint64{east, west}
 	if !b.Wtomove {
 		dirbitboards[0], dirbitboards[1] = dirbitboards[1], dirbitboards[0]
@@ -271,48 +829,54 @@ func (b *Board) pawnCaptures(moveList *[]Move, nonpinned uint64, allowDest uint6
 				canPromote = target <= 7
 			}
 			if uint8(target) == b.enpassant && b.enpassant != 0 {
-				// Apply, check actual legality, then unapply
-				// Warning: not thread safe
-				var ourPieces, oppPieces *Bitboards
-				var enpassantEnemy uint8
-				if b.Wtomove {
-					enpassantEnemy = uint8(move.To()) - 8
-					ourPieces = &(b.White)
-					oppPieces = &(b.Black)
-				} else {
-					enpassantEnemy = uint8(move.To()) + 8
-					ourPieces = &(b.Black)
-					oppPieces = &(b.White)
-				}
-				ourPieces.Pawns &= ^(uint64(1) << move.From())
-				ourPieces.All &= ^(uint64(1) << move.From())
-				ourPieces.Pawns |= (uint64(1) << move.To())
-				ourPieces.All |= (uint64(1) << move.To())
-				oppPieces.Pawns &= ^(uint64(1) << enpassantEnemy)
-				oppPieces.All &= ^(uint64(1) << enpassantEnemy)
-				kingInCheck := b.OurKingInCheck()
-				ourPieces.Pawns |= (uint64(1) << move.From())
-				ourPieces.All |= (uint64(1) << move.From())
-				ourPieces.Pawns &= ^(uint64(1) << move.To())
-				ourPieces.All &= ^(uint64(1) << move.To())
-				oppPieces.Pawns |= (uint64(1) << enpassantEnemy)
-				oppPieces.All |= (uint64(1) << enpassantEnemy)
-				if kingInCheck {
-					continue
+				if !legalEnPassant || b.enPassantIsLegal(uint8(move.From())) {
+					moveList.add(enPassantMove(move))
 				}
+				continue
 			}
+			captured, _ := determinePieceType(oppPieces, uint64(1)<<target)
 			if canPromote {
-				for i := Piece(Knight); i <= Queen; i++ {
+				for i := Piece(Knight); i <= b.maxPromotion(); i++ {
 					move.Setpromote(i)
-					*moveList = append(*moveList, move)
+					moveList.addMove(move, Pawn, captured)
 				}
 				continue
 			}
-			*moveList = append(*moveList, move)
+			moveList.addMove(move, Pawn, captured)
 		}
 	}
 }
 
+// Marks a pawn move as an en passant capture, which captures a pawn.
+func enPassantMove(m Move) ExtMove {
+	return ExtMove(m) | ExtMove(Pawn)<<16 | ExtMove(Pawn)<<19 | 1<<23
+}
+
+// Marks a king move as castling.
+func castleMove(m Move) ExtMove {
+	return ExtMove(m) | ExtMove(King)<<16 | 1<<22
+}
+
+// Whether capturing en passant with the pawn on origin leaves our king safe.
+// The captured pawn and our pawn both leave the rank, which might expose our king.
+func (b *Board) enPassantIsLegal(origin uint8) bool {
+	var ourKings, enpassantEnemy uint64
+	if b.Wtomove {
+		ourKings = b.White.Kings
+		enpassantEnemy = uint64(1) << (b.enpassant - 8)
+	} else {
+		ourKings = b.Black.Kings
+		enpassantEnemy = uint64(1) << (b.enpassant + 8)
+	}
+	if ourKings == 0 {
+		return true
+	}
+	occupancy := (b.White.All|b.Black.All)&^(uint64(1)<<origin)&^enpassantEnemy |
+		(uint64(1) << b.enpassant)
+	return !b.underAttackWithOccupancy(b.Wtomove, uint8(bits.TrailingZeros64(ourKings)),
+		occupancy, ^enpassantEnemy)
+}
+
 // A helper than generates bitboards for available pawn captures.
 func (b *Board) pawnCaptureBitboards(nonpinned uint64) (east uint64, west uint64) {
 	notHFile := uint64(0x7F7F7F7F7F7F7F7F)
@@ -339,7 +903,7 @@ func (b *Board) pawnCaptureBitboards(nonpinned uint64) (east uint64, west uint64
 
 // Generate all knight moves.
 // Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
-func (b *Board) knightMoves(moveList *[]Move, nonpinned uint64, allowDest uint64) {
+func (b *Board) knightMoves(moveList *moveSink, nonpinned uint64, allowDest uint64) {
 	var ourKnights, noFriendlyPieces uint64
 	if b.Wtomove {
 		ourKnights = b.White.Knights & nonpinned
@@ -352,88 +916,422 @@ func (b *Board) knightMoves(moveList *[]Move, nonpinned uint64, allowDest uint64
 		currentKnight := bits.TrailingZeros64(ourKnights)
 		ourKnights &= ourKnights - 1
 		targets := knightMasks[currentKnight] & noFriendlyPieces & allowDest
-		genMovesFromTargets(moveList, Square(currentKnight), targets)
+		b.genMovesFromTargets(moveList, Knight, Square(currentKnight), targets)
 	}
 }
 
-// Computes king moves without castling.
-func (b *Board) kingPushes(moveList *[]Move, ptrToOurBitboards *Bitboards) {
+// Computes king moves without castling. Only squares in allowDest can be moved to.
+func (b *Board) kingPushes(moveList *moveSink, ptrToOurBitboards *Bitboards, allowDest uint64) {
+	ourKingLocation := Square(bits.TrailingZeros64(ptrToOurBitboards.Kings))
+	b.genMovesFromTargets(moveList, King, ourKingLocation, b.kingPushTargets(ptrToOurBitboards, allowDest))
+}
+
+// Computes the squares the king can safely step to. Only squares in allowDest are included.
+func (b *Board) kingPushTargets(ptrToOurBitboards *Bitboards, allowDest uint64) uint64 {
+	if ptrToOurBitboards.Kings == 0 {
+		return 0
+	}
 	ourKingLocation := uint8(bits.TrailingZeros64(ptrToOurBitboards.Kings))
 	noFriendlyPieces := ^(ptrToOurBitboards.All)
 
-	// TODO(dylhunn): Modifying the board is NOT thread-safe.
-	// We only do this to avoid the king danger problem, aka moving away from a
-	// checking slider.
-	oldKings := ptrToOurBitboards.Kings
-	ptrToOurBitboards.Kings = 0
-	ptrToOurBitboards.All &= ^(uint64(1) << ourKingLocation)
-	targets := kingMasks[ourKingLocation] & noFriendlyPieces
-	for targets != 0 {
-		target := bits.TrailingZeros64(targets)
-		targets &= targets - 1
-		if b.UnderDirectAttack(b.Wtomove, uint8(target)) {
-			continue
+	// The king must not stay on the ray of a checking slider, so king danger is computed
+	// as if the king was already gone from its square.
+	occupancyWithoutKing := (b.White.All | b.Black.All) &^ (uint64(1) << ourKingLocation)
+	targets := kingMasks[ourKingLocation] & noFriendlyPieces & allowDest
+	for candidates := targets; candidates != 0; candidates &= candidates - 1 {
+		target := bits.TrailingZeros64(candidates)
+		if b.underAttackWithOccupancy(b.Wtomove, uint8(target), occupancyWithoutKing, everything) {
+			targets &^= uint64(1) << target
 		}
-		var move Move
-		move.Setfrom(Square(ourKingLocation)).Setto(Square(target))
-		*moveList = append(*moveList, move)
 	}
-
-	ptrToOurBitboards.Kings = oldKings
-	ptrToOurBitboards.All |= (1 << ourKingLocation)
+	return targets
 }
 
 // Generate all available king moves.
 // First, if castling is possible, verifies the checking prohibitions on castling.
 // Then, outputs castling moves (if any), and king moves.
-// Not thread-safe, since the king is removed from the board to compute
-// king-danger squares.
-func (b *Board) kingMoves(moveList *[]Move) {
+// Only squares in allowDest can be moved to.
+func (b *Board) kingMoves(moveList *moveSink, allowDest uint64) {
 	// castling
-	var ourKingLocation uint8
-	var canCastleQueenside, canCastleKingside bool
-	var ptrToOurBitboards *Bitboards
-	allPieces := b.White.All | b.Black.All
+	ptrToOurBitboards := &(b.Black)
 	if b.Wtomove {
-		ourKingLocation = uint8(bits.TrailingZeros64(b.White.Kings))
 		ptrToOurBitboards = &(b.White)
-		// To castle, we must have rights and a clear path
-		kingsideClear := allPieces&((1<<5)|(1<<6)) == 0
-		queensideClear := allPieces&((1<<3)|(1<<2)|(1<<1)) == 0
-		// skip the king square, since this won't be called while in check
-		canCastleQueenside = b.whiteCanCastleQueenside() &&
-			queensideClear && !b.anyUnderDirectAttack(true, 2, 3)
-		canCastleKingside = b.whiteCanCastleKingside() &&
-			kingsideClear && !b.anyUnderDirectAttack(true, 5, 6)
-	} else {
-		ourKingLocation = uint8(bits.TrailingZeros64(b.Black.Kings))
-		ptrToOurBitboards = &(b.Black)
-		kingsideClear := allPieces&((1<<61)|(1<<62)) == 0
-		queensideClear := allPieces&((1<<57)|(1<<58)|(1<<59)) == 0
-		// skip the king square, since this won't be called while in check
-		canCastleQueenside = b.blackCanCastleQueenside() &&
-			queensideClear && !b.anyUnderDirectAttack(false, 58, 59)
-		canCastleKingside = b.blackCanCastleKingside() &&
-			kingsideClear && !b.anyUnderDirectAttack(false, 61, 62)
 	}
+	canCastleKingside, canCastleQueenside := b.castlingAvailable()
 	if canCastleKingside {
-		var move Move
-		move.Setfrom(Square(ourKingLocation)).Setto(Square(ourKingLocation + 2))
-		*moveList = append(*moveList, move)
+		if move := b.castlingMove(true); allowDest&(uint64(1)<<move.To()) != 0 {
+			moveList.add(castleMove(move))
+		}
 	}
 	if canCastleQueenside {
-		var move Move
-		move.Setfrom(Square(ourKingLocation)).Setto(Square(ourKingLocation - 2))
-		*moveList = append(*moveList, move)
+		if move := b.castlingMove(false); allowDest&(uint64(1)<<move.To()) != 0 {
+			moveList.add(castleMove(move))
+		}
 	}
 
 	// non-castling
-	b.kingPushes(moveList, ptrToOurBitboards)
+	b.kingPushes(moveList, ptrToOurBitboards, allowDest)
+}
+
+// Returns whether we can castle on each side: we have the rights, the path is clear,
+// and the king does not pass through check. Must not be called while in check.
+func (b *Board) castlingAvailable() (kingside bool, queenside bool) {
+	kingsideClear, queensideClear := b.castlingPathsClear()
+	kingside = kingsideClear && b.castlingPathSafe(true)
+	queenside = queensideClear && b.castlingPathSafe(false)
+	return
+}
+
+// Returns whether we have the rights to castle on each side, with no pieces between the
+// king and the rook. This does not check whether the king would pass through check.
+func (b *Board) castlingPathsClear() (kingside bool, queenside bool) {
+	allPieces := b.White.All | b.Black.All
+	if b.canCastleKingside() {
+		_, _, mustBeEmpty, _ := castlingSquares(b.ourKingSquare(), b.castlingRook(true))
+		kingside = allPieces&mustBeEmpty == 0
+	}
+	if b.canCastleQueenside() {
+		_, _, mustBeEmpty, _ := castlingSquares(b.ourKingSquare(), b.castlingRook(false))
+		queenside = allPieces&mustBeEmpty == 0
+	}
+	return
+}
+
+// Returns whether none of the squares the king passes through while castling, including its
+// destination, is attacked. The king's own square is only checked if it doesn't move.
+func (b *Board) castlingPathSafe(kingside bool) bool {
+	king, rook := b.ourKingSquare(), b.castlingRook(kingside)
+	kingDest, rookDest, _, kingPath := castlingSquares(king, rook)
+	// The king doesn't shield the squares it passes through, but the rook only stops shielding
+	// the king's destination once it has moved too.
+	allPieces := b.White.All | b.Black.All
+	pathOccupancy := allPieces &^ (uint64(1) << king)
+	destOccupancy := pathOccupancy&^(uint64(1)<<rook) | uint64(1)<<rookDest
+	if b.variant == Atomic { // next to the opponent king, our king can't be captured
+		kingPath &^= b.oppKingZone()
+	}
+	for ; kingPath != 0; kingPath &= kingPath - 1 {
+		square := uint8(bits.TrailingZeros64(kingPath))
+		occupancy := pathOccupancy
+		if square == kingDest {
+			occupancy = destOccupancy
+		}
+		if b.underAttackWithOccupancy(b.Wtomove, square, occupancy, everything) {
+			return false
+		}
+	}
+	return true
+}
+
+// Computes the squares involved in castling with the king on king and the rook on rook,
+// which must be on the same rank. The king ends up on the g or c file, with the rook beside
+// it on the f or d file. Every square that either piece passes over, or lands on, must be
+// empty (apart from the king and the rook themselves), and none of the squares on the king's
+// path may be attacked.
+func castlingSquares(king uint8, rook uint8) (kingDest uint8, rookDest uint8, mustBeEmpty uint64,
+	kingPath uint64) {
+	backRank := king &^ 7
+	if rook > king {
+		kingDest, rookDest = backRank+6, backRank+5
+	} else {
+		kingDest, rookDest = backRank+2, backRank+3
+	}
+	kingPath = squaresBetweenInclusive(king, kingDest)
+	mustBeEmpty = (kingPath | squaresBetweenInclusive(rook, rookDest)) &^
+		(uint64(1) << king) &^ (uint64(1) << rook)
+	if kingDest != king {
+		kingPath &^= uint64(1) << king
+	}
+	return
+}
+
+// Returns a bitboard of the squares from a to b, including both. The squares must be on the
+// same rank.
+func squaresBetweenInclusive(a uint8, b uint8) uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	return (uint64(1)<<b)<<1 - uint64(1)<<a
+}
+
+// Returns the castling move on the given side. Normally it is encoded as the king moving two
+// squares, but in Chess960 it is encoded as the king capturing its own rook.
+func (b *Board) castlingMove(kingside bool) Move {
+	king, rook := b.ourKingSquare(), b.castlingRook(kingside)
+	var move Move
+	move.Setfrom(Square(king))
+	if b.chess960 {
+		move.Setto(Square(rook))
+	} else {
+		kingDest, _, _, _ := castlingSquares(king, rook)
+		move.Setto(Square(kingDest))
+	}
+	return move
+}
+
+// If the king move m is a castling move, returns the square of the rook that castles, and
+// true. Only valid for moves of our king.
+func (b *Board) castlingRookFor(m Move) (uint8, bool) {
+	if b.chess960 {
+		ourRooks := b.Black.Rooks
+		if b.Wtomove {
+			ourRooks = b.White.Rooks
+		}
+		return m.To(), ourRooks&(uint64(1)<<m.To()) != 0
+	}
+	if m.To() == m.From()+2 {
+		return b.castlingRook(true), true
+	} else if int(m.To()) == int(m.From())-2 {
+		return b.castlingRook(false), true
+	}
+	return 0, false
+}
+
+// The square of our king. Assumes there is only one king.
+func (b *Board) ourKingSquare() uint8 {
+	if b.Wtomove {
+		return uint8(bits.TrailingZeros64(b.White.Kings))
+	}
+	return uint8(bits.TrailingZeros64(b.Black.Kings))
+}
+
+// Generate Crazyhouse drops of the pieces in our pocket. Only squares in allowDest can be
+// dropped on.
+func (b *Board) dropMoves(moveList *moveSink, allowDest uint64) {
+	pocket := &b.pockets[sideIndex(b.Wtomove)]
+	for p := Piece(Pawn); p <= Queen; p++ {
+		if pocket[p-1] == 0 {
+			continue
+		}
+		for targets := b.dropTargets(p, allowDest); targets != 0; targets &= targets - 1 {
+			var move Move
+			move.Setto(Square(bits.TrailingZeros64(targets))).Setdrop(p)
+			moveList.addMove(move, p, Nothing)
+		}
+	}
+}
+
+// Returns the squares in allowDest that a piece can be dropped on: any empty square, except
+// that pawns can't be dropped on the first or last rank.
+func (b *Board) dropTargets(p Piece, allowDest uint64) uint64 {
+	targets := ^(b.White.All | b.Black.All) & allowDest
+	if p == Pawn {
+		targets &^= onlyRank[0] | onlyRank[7]
+	}
+	return targets
+}
+
+// Generates the legal Atomic moves in the categories selected by mode, adding them to moves.
+// Only the pieces on squares in movable are moved. Since a capture can remove any of the
+// pieces around it, each pseudo-legal move is tested for legality individually.
+// Both kings must be on the board.
+func (b *Board) generateAtomicMoves(moves *moveSink, mode genMode, movable uint64) {
+	var candidates ExtMoveList
+	b.generatePseudoLegalMoves(candidates.sink())
+	for _, e := range candidates.Slice() {
+		if movable&(uint64(1)<<e.From()) == 0 {
+			continue
+		}
+		move := e.Move()
+		// Captures and queen promotions are generated with the captures, like in chess.
+		isCapture := e.IsCapture() || move.Promote() == Queen
+		if (isCapture && mode&genCaptures == 0) || (!isCapture && mode&genQuiets == 0) {
+			continue
+		}
+		if b.atomicMoveIsLegal(move) {
+			moves.add(e)
+		}
+	}
+}
+
+// Determines whether a pseudo-legal move is legal in Atomic chess. A capture explodes the
+// capturing piece, and every piece but pawns next to the destination square. A move is legal
+// if it explodes the opponent king without exploding ours, or if our king survives and is
+// not in check afterwards. Touching kings can't check each other, since capturing one would
+// explode the other.
+func (b *Board) atomicMoveIsLegal(m Move) bool {
+	var ourPieces, oppPieces *Bitboards
+	if b.Wtomove {
+		ourPieces, oppPieces = &(b.White), &(b.Black)
+	} else {
+		ourPieces, oppPieces = &(b.Black), &(b.White)
+	}
+	fromBitboard := uint64(1) << m.From()
+	toBitboard := uint64(1) << m.To()
+	ourKing := ourPieces.Kings
+	if ourKing&fromBitboard != 0 {
+		if rook, isCastle := b.castlingRookFor(m); isCastle {
+			return !b.OurKingInCheck() && b.castlingPathSafe(rook > m.From())
+		}
+		if oppPieces.All&toBitboard != 0 { // kings can't capture
+			return false
+		}
+		ourKing = toBitboard
+	}
+	allPieces := b.White.All | b.Black.All
+	exploded := b.atomicExplosion(m)
+	if exploded&ourKing != 0 {
+		return false
+	}
+	if exploded&oppPieces.Kings != 0 {
+		return true
+	}
+	ourKingLocation := uint8(bits.TrailingZeros64(ourKing))
+	if kingMasks[ourKingLocation]&oppPieces.Kings != 0 {
+		return true
+	}
+	occupancy := (allPieces&^fromBitboard | toBitboard) &^ exploded
+	return !b.underAttackWithOccupancy(b.Wtomove, ourKingLocation, occupancy, ^exploded)
+}
+
+// Returns the squares emptied by the explosion of an Atomic capture: the blast around the
+// destination, the capturing piece and, for en passant, the captured pawn. Quiet moves don't
+// explode anything.
+func (b *Board) atomicExplosion(m Move) uint64 {
+	var ourPieces, oppPieces *Bitboards
+	epDelta := 8
+	if b.Wtomove {
+		ourPieces, oppPieces, epDelta = &(b.White), &(b.Black), -8
+	} else {
+		ourPieces, oppPieces = &(b.Black), &(b.White)
+	}
+	fromBitboard := uint64(1) << m.From()
+	toBitboard := uint64(1) << m.To()
+	explosive := (b.White.All | b.Black.All) &^ (b.White.Pawns | b.Black.Pawns)
+	if oppPieces.All&toBitboard != 0 {
+		return atomicBlast(m.To(), explosive) | fromBitboard
+	}
+	if ourPieces.Pawns&fromBitboard != 0 && b.enpassant != 0 && m.To() == b.enpassant {
+		return atomicBlast(m.To(), explosive) | fromBitboard | uint64(1)<<uint8(int(m.To())+epDelta)
+	}
+	return 0
+}
+
+// Generates the legal Antichess moves in the categories selected by mode, adding them to moves.
+// Only the pieces on squares in movable are moved. There is no check, so pins are ignored,
+// and kings move like any other piece. But captures are compulsory: if any piece can capture,
+// only captures are legal.
+func (b *Board) generateAntichessMoves(moves *moveSink, mode genMode, movable uint64) {
+	var candidates ExtMoveList
+	oppPieces := b.White.All
+	if b.Wtomove {
+		oppPieces = b.Black.All
+	}
+	b.antichessMoves(candidates.sink(), oppPieces, true)
+	if candidates.Len == 0 {
+		b.antichessMoves(candidates.sink(), ^(b.White.All | b.Black.All), false)
+	}
+	for _, e := range candidates.Slice() {
+		if movable&(uint64(1)<<e.From()) == 0 {
+			continue
+		}
+		// Captures and queen promotions are generated with the captures, like in chess.
+		move := e.Move()
+		isCapture := e.IsCapture() || move.Promote() == Queen
+		if (isCapture && mode&genCaptures != 0) || (!isCapture && mode&genQuiets != 0) {
+			moves.add(e)
+		}
+	}
+}
+
+// Generates the moves of every piece to the squares in allowDest, including the pawn captures
+// (with en passant) if captures is set, or the pawn pushes otherwise.
+func (b *Board) antichessMoves(moveList *moveSink, allowDest uint64, captures bool) {
+	if captures {
+		b.pawnCaptures(moveList, everything, allowDest, false)
+	} else {
+		b.pawnPushes(moveList, everything, allowDest)
+	}
+	b.knightMoves(moveList, everything, allowDest)
+	b.rookMoves(moveList, everything, allowDest)
+	b.bishopMoves(moveList, everything, allowDest)
+	b.queenMoves(moveList, everything, allowDest)
+	ourKings := b.Black.Kings
+	if b.Wtomove {
+		ourKings = b.White.Kings
+	}
+	for ; ourKings != 0; ourKings &= ourKings - 1 {
+		king := bits.TrailingZeros64(ourKings)
+		b.genMovesFromTargets(moveList, King, Square(king), kingMasks[king]&allowDest)
+	}
+}
+
+// Generates the legal Racing Kings moves in the categories selected by mode, adding them to
+// moves. Only the pieces on squares in movable are moved. These are the legal chess moves,
+// except that no move may give check.
+func (b *Board) generateRacingKingsMoves(moves *moveSink, mode genMode, movable uint64) {
+	ci := b.computeCheckInfo()
+	var chessMoves ExtMoveList
+	b.generateChessMoves(chessMoves.sink(), mode, movable)
+	for _, e := range chessMoves.Slice() {
+		if !b.givesCheck(e.Move(), &ci) {
+			moves.add(e)
+		}
+	}
+}
+
+// Whether black can answer a white king on the 8th rank in Racing Kings, by moving its king
+// to the 8th rank too.
+func (b *Board) racingKingsBlackCanEqualize() bool {
+	var kingMoves MoveList
+	b.generateRacingKingsMoves(kingMoves.sink(), genAll, b.Black.Kings)
+	for _, move := range kingMoves.Slice() {
+		if move.To()/8 == 7 {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether any of our pieces can capture in Antichess, which makes captures compulsory.
+func (b *Board) antichessCanCapture() bool {
+	var captures MoveList
+	oppPieces := b.White.All
+	if b.Wtomove {
+		oppPieces = b.Black.All
+	}
+	b.antichessMoves(captures.sink(), oppPieces, true)
+	return captures.Len != 0
+}
+
+// Whether a pseudo-legal Antichess move captures, including en passant.
+func (b *Board) antichessIsCapture(m Move) bool {
+	if (b.White.All|b.Black.All)&(uint64(1)<<m.To()) != 0 {
+		return true
+	}
+	return b.enpassant != 0 && m.To() == b.enpassant &&
+		(b.White.Pawns|b.Black.Pawns)&(uint64(1)<<m.From()) != 0
+}
+
+// The most valuable piece that pawns can promote to: a queen, or a king in Antichess.
+func (b *Board) maxPromotion() Piece {
+	if b.variant == Antichess {
+		return King
+	}
+	return Queen
+}
+
+// Returns the squares exploded by a capture on square: the square itself, and the squares
+// around it that are occupied by the pieces in explosive.
+func atomicBlast(square uint8, explosive uint64) uint64 {
+	return uint64(1)<<square | kingMasks[square]&explosive
+}
+
+// Returns the squares next to the opponent king, if there is one.
+func (b *Board) oppKingZone() uint64 {
+	oppKings := b.White.Kings
+	if b.Wtomove {
+		oppKings = b.Black.Kings
+	}
+	if oppKings == 0 {
+		return 0
+	}
+	return kingMasks[bits.TrailingZeros64(oppKings)]
 }
 
 // Generate all rook moves using magic bitboards.
 // Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
-func (b *Board) rookMoves(moveList *[]Move, nonpinned uint64, allowDest uint64) {
+func (b *Board) rookMoves(moveList *moveSink, nonpinned uint64, allowDest uint64) {
 	var ourRooks, friendlyPieces uint64
 	if b.Wtomove {
 		ourRooks = b.White.Rooks & nonpinned
@@ -447,13 +1345,13 @@ func (b *Board) rookMoves(moveList *[]Move, nonpinned uint64, allowDest uint64)
 		currRook := uint8(bits.TrailingZeros64(ourRooks))
 		ourRooks &= ourRooks - 1
 		targets := CalculateRookMoveBitboard(currRook, allPieces) & (^friendlyPieces) & allowDest
-		genMovesFromTargets(moveList, Square(currRook), targets)
+		b.genMovesFromTargets(moveList, Rook, Square(currRook), targets)
 	}
 }
 
 // Generate all bishop moves using magic bitboards.
 // Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
-func (b *Board) bishopMoves(moveList *[]Move, nonpinned uint64, allowDest uint64) {
+func (b *Board) bishopMoves(moveList *moveSink, nonpinned uint64, allowDest uint64) {
 	var ourBishops, friendlyPieces uint64
 	if b.Wtomove {
 		ourBishops = b.White.Bishops & nonpinned
@@ -467,13 +1365,13 @@ func (b *Board) bishopMoves(moveList *[]Move, nonpinned uint64, allowDest uint64
 		currBishop := uint8(bits.TrailingZeros64(ourBishops))
 		ourBishops &= ourBishops - 1
 		targets := CalculateBishopMoveBitboard(currBishop, allPieces) & (^friendlyPieces) & allowDest
-		genMovesFromTargets(moveList, Square(currBishop), targets)
+		b.genMovesFromTargets(moveList, Bishop, Square(currBishop), targets)
 	}
 }
 
 // Generate all queen moves using magic bitboards.
 // Only pieces marked nonpinned can be moved. Only squares in allowDest can be moved to.
-func (b *Board) queenMoves(moveList *[]Move, nonpinned uint64, allowDest uint64) {
+func (b *Board) queenMoves(moveList *moveSink, nonpinned uint64, allowDest uint64) {
 	var ourQueens, friendlyPieces uint64
 	if b.Wtomove {
 		ourQueens = b.White.Queens & nonpinned
@@ -488,22 +1386,223 @@ func (b *Board) queenMoves(moveList *[]Move, nonpinned uint64, allowDest uint64)
 		ourQueens &= ourQueens - 1
 		// bishop motion
 		diag_targets := CalculateBishopMoveBitboard(currQueen, allPieces) & (^friendlyPieces) & allowDest
-		genMovesFromTargets(moveList, Square(currQueen), diag_targets)
+		b.genMovesFromTargets(moveList, Queen, Square(currQueen), diag_targets)
 		// rook motion
 		ortho_targets := CalculateRookMoveBitboard(currQueen, allPieces) & (^friendlyPieces) & allowDest
-		genMovesFromTargets(moveList, Square(currQueen), ortho_targets)
+		b.genMovesFromTargets(moveList, Queen, Square(currQueen), ortho_targets)
+	}
+}
+
+// Helper: converts a targets bitboard into moves of a piece, and adds them to the moves list.
+// The quiet moves come first, then the captures, grouped by the type of piece they capture.
+func (b *Board) genMovesFromTargets(moveList *moveSink, piece Piece, origin Square, targets uint64) {
+	oppPieces := &(b.White)
+	if b.Wtomove {
+		oppPieces = &(b.Black)
 	}
+	captures := targets & oppPieces.All
+	addMovesFromTargets(moveList, piece, Nothing, origin, targets&^captures)
+	if captures == 0 {
+		return
+	}
+	addMovesFromTargets(moveList, piece, Pawn, origin, captures&oppPieces.Pawns)
+	addMovesFromTargets(moveList, piece, Knight, origin, captures&oppPieces.Knights)
+	addMovesFromTargets(moveList, piece, Bishop, origin, captures&oppPieces.Bishops)
+	addMovesFromTargets(moveList, piece, Rook, origin, captures&oppPieces.Rooks)
+	addMovesFromTargets(moveList, piece, Queen, origin, captures&oppPieces.Queens)
+	addMovesFromTargets(moveList, piece, King, origin, captures&oppPieces.Kings)
 }
 
-// Helper: converts a targets bitboard into moves, and adds them to the moves list.
-func genMovesFromTargets(moveList *[]Move, origin Square, targets uint64) {
+// Adds the moves of a piece from origin to each of the targets, capturing the given piece.
+func addMovesFromTargets(moveList *moveSink, piece Piece, captured Piece, origin Square, targets uint64) {
 	for targets != 0 {
 		target := bits.TrailingZeros64(targets)
 		targets &= targets - 1
 		var move Move
 		move.Setfrom(origin).Setto(Square(target))
-		*moveList = append(*moveList, move)
+		moveList.addMove(move, piece, captured)
+	}
+}
+
+// Information used to quickly decide whether our moves give check to the opponent king.
+type checkInfo struct {
+	oppKing      uint8
+	checkSquares [7]uint64 // for each piece type, the squares from which it would attack the king
+	discoverers  uint64    // our pieces that are the only blocker between our slider and the king
+}
+
+// Computes the check squares and discovered-check blockers around the opponent king.
+func (b *Board) computeCheckInfo() checkInfo {
+	var ci checkInfo
+	var ourPieces, oppPieces *Bitboards
+	if b.Wtomove {
+		ourPieces, oppPieces = &(b.White), &(b.Black)
+	} else {
+		ourPieces, oppPieces = &(b.Black), &(b.White)
+	}
+	allPieces := b.White.All | b.Black.All
+	ci.oppKing = uint8(bits.TrailingZeros64(oppPieces.Kings))
+	if oppPieces.Kings == 0 { // there is no king to check
+		return ci
 	}
+	oppKingBitboard := oppPieces.Kings
+	if b.Wtomove {
+		ci.checkSquares[Pawn] = (oppKingBitboard>>7)&^onlyFile[0] | (oppKingBitboard>>9)&^onlyFile[7]
+	} else {
+		ci.checkSquares[Pawn] = (oppKingBitboard<<7)&^onlyFile[7] | (oppKingBitboard<<9)&^onlyFile[0]
+	}
+	ci.checkSquares[Knight] = knightMasks[ci.oppKing]
+	ci.checkSquares[Bishop] = CalculateBishopMoveBitboard(ci.oppKing, allPieces)
+	ci.checkSquares[Rook] = CalculateRookMoveBitboard(ci.oppKing, allPieces)
+	ci.checkSquares[Queen] = ci.checkSquares[Bishop] | ci.checkSquares[Rook]
+
+	// A blocker is a discoverer iff removing it exposes the king to one of our sliders.
+	candidates := ci.checkSquares[Rook] & ourPieces.All
+	for candidates != 0 {
+		candidate := candidates & -candidates
+		candidates &= candidates - 1
+		if CalculateRookMoveBitboard(ci.oppKing, allPieces&^candidate)&(ourPieces.Rooks|ourPieces.Queens) != 0 {
+			ci.discoverers |= candidate
+		}
+	}
+	candidates = ci.checkSquares[Bishop] & ourPieces.All
+	for candidates != 0 {
+		candidate := candidates & -candidates
+		candidates &= candidates - 1
+		if CalculateBishopMoveBitboard(ci.oppKing, allPieces&^candidate)&(ourPieces.Bishops|ourPieces.Queens) != 0 {
+			ci.discoverers |= candidate
+		}
+	}
+	return ci
+}
+
+// Determines whether a legal move gives check to the opponent king.
+func (b *Board) givesCheck(m Move, ci *checkInfo) bool {
+	var ourPieces *Bitboards
+	if b.Wtomove {
+		ourPieces = &(b.White)
+	} else {
+		ourPieces = &(b.Black)
+	}
+	if b.variant == Antichess || ci.oppKing == 64 { // there is no king to check
+		return false
+	}
+	fromBitboard := uint64(1) << m.From()
+	toBitboard := uint64(1) << m.To()
+	if b.variant == Atomic {
+		if exploded := b.atomicExplosion(m); exploded != 0 {
+			return b.atomicCaptureGivesCheck(ci, exploded)
+		}
+		// a king next to the opponent king can't be in check
+		if kingMasks[ci.oppKing]&ourPieces.Kings&^fromBitboard != 0 {
+			return false
+		}
+		if ourPieces.Kings&fromBitboard != 0 {
+			return b.atomicKingMoveGivesCheck(m, ci)
+		}
+	}
+	if drop := m.Drop(); drop != Nothing { // a dropped piece can only give direct check
+		return toBitboard&ci.checkSquares[drop] != 0
+	}
+	pieceType, _ := determinePieceType(ourPieces, fromBitboard)
+	var castlingRook uint8
+	isCastle := false
+	if pieceType == King {
+		castlingRook, isCastle = b.castlingRookFor(m)
+	}
+	isEnPassant := pieceType == Pawn && b.enpassant != 0 && m.To() == b.enpassant
+	isPromotion := m.Promote() != Nothing
+	// Fast path for ordinary moves: direct checks, and moves that can't discover check.
+	if !isCastle && !isEnPassant && !isPromotion {
+		if toBitboard&ci.checkSquares[pieceType] != 0 {
+			return true
+		}
+		if fromBitboard&ci.discoverers == 0 {
+			return false
+		}
+	}
+
+	// Otherwise, compute the attacks on the king after the move.
+	allPieces := (b.White.All|b.Black.All)&^fromBitboard | toBitboard
+	ourRooks := (ourPieces.Rooks | ourPieces.Queens) &^ fromBitboard
+	ourBishops := (ourPieces.Bishops | ourPieces.Queens) &^ fromBitboard
+	if isCastle {
+		kingDest, rookDest, _, _ := castlingSquares(m.From(), castlingRook)
+		allPieces = (b.White.All|b.Black.All)&^fromBitboard&^(uint64(1)<<castlingRook) |
+			(uint64(1) << kingDest) | (uint64(1) << rookDest)
+		ourRooks = ourRooks&^(uint64(1)<<castlingRook) | (uint64(1) << rookDest)
+	}
+	if isEnPassant {
+		if b.Wtomove {
+			allPieces &^= toBitboard >> 8
+		} else {
+			allPieces &^= toBitboard << 8
+		}
+	}
+	if isPromotion {
+		pieceType = m.Promote()
+	}
+	switch pieceType {
+	case Pawn:
+		if toBitboard&ci.checkSquares[Pawn] != 0 {
+			return true
+		}
+	case Knight:
+		if toBitboard&ci.checkSquares[Knight] != 0 {
+			return true
+		}
+	case Bishop:
+		ourBishops |= toBitboard
+	case Rook:
+		ourRooks |= toBitboard
+	case Queen:
+		ourBishops |= toBitboard
+		ourRooks |= toBitboard
+	}
+	return CalculateRookMoveBitboard(ci.oppKing, allPieces)&ourRooks != 0 ||
+		CalculateBishopMoveBitboard(ci.oppKing, allPieces)&ourBishops != 0
+}
+
+// Determines whether an Atomic capture, which empties the exploded squares, gives check.
+// The capturing piece explodes too, so only our remaining pieces can attack the king.
+func (b *Board) atomicCaptureGivesCheck(ci *checkInfo, exploded uint64) bool {
+	var ourPieces, oppPieces *Bitboards
+	if b.Wtomove {
+		ourPieces, oppPieces = &(b.White), &(b.Black)
+	} else {
+		ourPieces, oppPieces = &(b.Black), &(b.White)
+	}
+	if exploded&oppPieces.Kings != 0 {
+		return false // an exploded king is not in check
+	}
+	if kingMasks[ci.oppKing]&ourPieces.Kings&^exploded != 0 {
+		return false // neither is a king touching ours
+	}
+	occupancy := (b.White.All | b.Black.All) &^ exploded
+	return b.underAttackWithOccupancy(!b.Wtomove, ci.oppKing, occupancy, ^exploded)
+}
+
+// Determines whether a quiet Atomic king move gives check. A king that stops touching the
+// opponent king uncovers all of our attacks on it, not only the discovered ones.
+func (b *Board) atomicKingMoveGivesCheck(m Move, ci *checkInfo) bool {
+	fromBitboard := uint64(1) << m.From()
+	kingDest, rookDest, rookBitboard := m.To(), uint8(0), uint64(0)
+	rook, isCastle := b.castlingRookFor(m)
+	if isCastle {
+		kingDest, rookDest, _, _ = castlingSquares(m.From(), rook)
+		rookBitboard = uint64(1) << rook
+	}
+	if kingMasks[ci.oppKing]&(uint64(1)<<kingDest) != 0 {
+		return false
+	}
+	occupancy := (b.White.All|b.Black.All)&^fromBitboard&^rookBitboard | uint64(1)<<kingDest
+	if isCastle {
+		occupancy |= uint64(1) << rookDest
+		if CalculateRookMoveBitboard(ci.oppKing, occupancy)&(uint64(1)<<rookDest) != 0 {
+			return true
+		}
+	}
+	return b.underAttackWithOccupancy(!b.Wtomove, ci.oppKing, occupancy, ^(fromBitboard | rookBitboard))
 }
 
 // Variadic function that returns whether any of the specified squares is being attacked
@@ -518,6 +1617,9 @@ func (b *Board) anyUnderDirectAttack(byBlack bool, squares ...uint8) bool {
 }
 
 func (b *Board) OurKingInCheck() bool {
+	if b.variant == Antichess { // kings are ordinary pieces
+		return false
+	}
 	byBlack := b.Wtomove
 	var origin uint8
 	if b.Wtomove {
@@ -525,10 +1627,38 @@ func (b *Board) OurKingInCheck() bool {
 	} else {
 		origin = uint8(bits.TrailingZeros64(b.Black.Kings))
 	}
+	if origin == 64 { // no king, no check
+		return false
+	}
+	if b.variant == Atomic && b.oppKingZone()&(uint64(1)<<origin) != 0 {
+		return false // a king touching the opponent king is not in check
+	}
 	count, _ := b.countAttacks(byBlack, origin, 1)
 	return count >= 1
 }
 
+// Determines whether a square is attacked by the given side, as if the occupied squares were
+// exactly those in occupancy. Only attackers inside attackerMask are considered, so pieces
+// that would be captured can be excluded. The board itself is not modified.
+func (b *Board) underAttackWithOccupancy(byBlack bool, origin uint8, occupancy uint64, attackerMask uint64) bool {
+	var opponentPieces *Bitboards
+	var pawnAttackers uint64
+	originBitboard := uint64(1) << origin
+	if byBlack {
+		opponentPieces = &(b.Black)
+		pawnAttackers = (originBitboard<<7)&^onlyFile[7] | (originBitboard<<9)&^onlyFile[0]
+	} else {
+		opponentPieces = &(b.White)
+		pawnAttackers = (originBitboard>>7)&^onlyFile[0] | (originBitboard>>9)&^onlyFile[7]
+	}
+	attackers := knightMasks[origin] & opponentPieces.Knights
+	attackers |= kingMasks[origin] & opponentPieces.Kings
+	attackers |= pawnAttackers & opponentPieces.Pawns
+	attackers |= CalculateBishopMoveBitboard(origin, occupancy) & (opponentPieces.Bishops | opponentPieces.Queens)
+	attackers |= CalculateRookMoveBitboard(origin, occupancy) & (opponentPieces.Rooks | opponentPieces.Queens)
+	return attackers&attackerMask != 0
+}
+
 // Determine if a square is under attack. Potentially expensive.
 func (b *Board) UnderDirectAttack(byBlack bool, origin uint8) bool {
 	count, _ := b.countAttacks(byBlack, origin, 1)
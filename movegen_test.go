@@ -3,6 +3,7 @@ package dragontoothmg
 import (
 	"fmt"
 	"math/bits"
+	"sync"
 	"testing"
 )
 
@@ -12,12 +13,12 @@ func TestPawnPushes(t *testing.T) {
 		"rnbqkbnr/ppp2pp1/3p4/4p3/3N1P2/P1n5/2PPP3/R1BQKBNR b KQkq - 0 0": 12,
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.pawnPushes(&moves, everything, everything)
-		if len(moves) != v {
+		b.pawnPushes(moves.sink(), everything, everything)
+		if moves.Len != v {
 			t.Error("Pawn pushes: wrong length. Expected", v, "but got",
-				len(moves), "for FEN", b.ToFen())
+				moves.Len, "for FEN", b.ToFen())
 		}
 	}
 }
@@ -29,12 +30,12 @@ func TestPawnCaptures(t *testing.T) {
 		"rnbqkbnr/ppp2pp1/3p4/4pP2/3N4/P1n5/2PPP3/R1BQKBNR w KQkq e6 0 0": 2,
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.pawnCaptures(&moves, everything, everything)
-		if len(moves) != v {
+		b.pawnCaptures(moves.sink(), everything, everything, true)
+		if moves.Len != v {
 			t.Error("Pawn captures: wrong length. Expected", v, "but got",
-				len(moves), "for FEN", b.ToFen())
+				moves.Len, "for FEN", b.ToFen())
 		}
 	}
 }
@@ -63,17 +64,17 @@ func TestKnightPosition0(t *testing.T) {
 	blackpieces := Bitboards{Pawns: blackPawns, Knights: blackKnights, All: blackPawns | blackKnights}
 	testboard := Board{White: whitepieces, Black: blackpieces, Wtomove: true}
 
-	moves := make([]Move, 0, 45)
-	testboard.knightMoves(&moves, everything, everything)
-	if len(moves) != 20 {
-		t.Error("Knight moves: wrong length. Expected 20, got", len(moves))
+	var moves MoveList
+	testboard.knightMoves(moves.sink(), everything, everything)
+	if moves.Len != 20 {
+		t.Error("Knight moves: wrong length. Expected 20, got", moves.Len)
 	}
 
 	testboard.Wtomove = false
-	moves2 := make([]Move, 0, 45)
-	testboard.knightMoves(&moves2, everything, everything)
-	if len(moves2) != 27 {
-		t.Error("Knight moves: wrong length. Expected 27, got", len(moves2))
+	var moves2 MoveList
+	testboard.knightMoves(moves2.sink(), everything, everything)
+	if moves2.Len != 27 {
+		t.Error("Knight moves: wrong length. Expected 27, got", moves2.Len)
 	}
 }
 
@@ -89,12 +90,12 @@ func TestKingPositions(t *testing.T) {
 		"4k3/8/8/8/8/8/8/4K1NR w K - 0 0":                             5, // short castle blocked
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.kingMoves(&moves)
-		if len(moves) != v {
+		b.kingMoves(moves.sink(), everything)
+		if moves.Len != v {
 			t.Error("King moves: wrong length. Expected", v, "but got",
-				len(moves), "\nFor position:", k)
+				moves.Len, "\nFor position:", k)
 		}
 	}
 }
@@ -111,11 +112,11 @@ func TestRookPositions(t *testing.T) {
 		"8/8/8/3r4/8/8/8/8 b KQkq -":                            14,
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.rookMoves(&moves, everything, everything)
-		if len(moves) != v {
-			t.Error("Rook moves: wrong length. Expected", v, "but got", len(moves))
+		b.rookMoves(moves.sink(), everything, everything)
+		if moves.Len != v {
+			t.Error("Rook moves: wrong length. Expected", v, "but got", moves.Len)
 		}
 	}
 }
@@ -128,11 +129,11 @@ func TestBishopPositions(t *testing.T) {
 		"rnbqkb1r/pp2pppp/8/4P3/5bN1/8/PPP2PPP/RNBQKBNR b KQkq -": 12,
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.bishopMoves(&moves, everything, everything)
-		if len(moves) != v {
-			t.Error("Bishop moves: wrong length. Expected", v, "but got", len(moves))
+		b.bishopMoves(moves.sink(), everything, everything)
+		if moves.Len != v {
+			t.Error("Bishop moves: wrong length. Expected", v, "but got", moves.Len)
 		}
 	}
 }
@@ -145,11 +146,11 @@ func TestQueenPositions(t *testing.T) {
 		"6nq/6p1/2B4n/1rB2r1R/5q2/2P5/1Q4n1/2B5 b - -":         21,
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.queenMoves(&moves, everything, everything)
-		if len(moves) != v {
-			t.Error("Queen moves: wrong length. Expected", v, "but got", len(moves))
+		b.queenMoves(moves.sink(), everything, everything)
+		if moves.Len != v {
+			t.Error("Queen moves: wrong length. Expected", v, "but got", moves.Len)
 		}
 	}
 }
@@ -229,11 +230,11 @@ func TestPinnedBishop(t *testing.T) {
 		"4k3/3b1b2/2Q3Q1/8/8/8/8/4K3 b - - 0 0": 2, // two close pins
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.generatePinnedMoves(&moves, everything)
-		if len(moves) != v {
-			t.Error("Legal moves for pinned bishops: wrong length. Expected", v, "but got", len(moves), "for position", b.ToFen())
+		b.generatePinnedMoves(moves.sink(), everything, true)
+		if moves.Len != v {
+			t.Error("Legal moves for pinned bishops: wrong length. Expected", v, "but got", moves.Len, "for position", b.ToFen())
 		}
 	}
 }
@@ -244,11 +245,11 @@ func TestPinnedKnight(t *testing.T) {
 		"4k3/8/8/8/1q6/2N5/8/4K3 w - - 0 0":     0, // normal pin
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.generatePinnedMoves(&moves, everything)
-		if len(moves) != v {
-			t.Error("Legal moves for pinned bishops: wrong length. Expected", v, "but got", len(moves), "for position", b.ToFen())
+		b.generatePinnedMoves(moves.sink(), everything, true)
+		if moves.Len != v {
+			t.Error("Legal moves for pinned bishops: wrong length. Expected", v, "but got", moves.Len, "for position", b.ToFen())
 		}
 	}
 }
@@ -259,11 +260,11 @@ func TestPinnedQueen(t *testing.T) {
 		"4k3/8/4r3/4Q3/1q6/2Q5/8/4K3 w - - 0 0": 6,
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		b.generatePinnedMoves(&moves, everything)
-		if len(moves) != v {
-			t.Error("Legal moves for pinned bishops: wrong length. Expected", v, "but got", len(moves), "for position", b.ToFen())
+		b.generatePinnedMoves(moves.sink(), everything, true)
+		if moves.Len != v {
+			t.Error("Legal moves for pinned bishops: wrong length. Expected", v, "but got", moves.Len, "for position", b.ToFen())
 		}
 	}
 }
@@ -292,11 +293,11 @@ func TestDiagPins(t *testing.T) {
 		"4k3/8/8/b7/7q/6P1/8/4K3 w - - 0 0":         algebraicToIndexFatal("g3"),
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		result := b.generatePinnedMoves(&moves, everything)
-		if len(moves) != v {
-			t.Error("Legal moves for diagonal pins: wrong length. Expected", v, "but got", len(moves), "for position", b.ToFen())
+		result := b.generatePinnedMoves(moves.sink(), everything, true)
+		if moves.Len != v {
+			t.Error("Legal moves for diagonal pins: wrong length. Expected", v, "but got", moves.Len, "for position", b.ToFen())
 		}
 		if pinLocs[k] == 64 {
 			if result != 0 {
@@ -355,12 +356,14 @@ func TestOrthoPins(t *testing.T) {
 		"rnbqkbnr/ppp1pppp/4Q3/8/4p3/8/PPPP1PPP/RNB1KBNR b KQkq - 0 3": algebraicToIndexFatal("e7"), // pawn is pinned with double pawn in file
 	}
 	for k, v := range positions {
-		moves := make([]Move, 0, 45)
+		var moves MoveList
 		b := ParseFen(k)
-		result := b.generatePinnedMoves(&moves, everything)
-		if len(moves) != v {
-			t.Error("Legal moves for orthogonal pins: wrong length. Expected", v, "but got", len(moves), "for position", b.ToFen())
-			printMoves(moves)
+		result := b.generatePinnedMoves(moves.sink(), everything, true)
+		if moves.Len != v {
+			t.Error("Legal moves for orthogonal pins: wrong length. Expected", v, "but got", moves.Len, "for position", b.ToFen())
+			for _, m := range moves.Slice() {
+				t.Log(&m)
+			}
 		}
 		if pinLocs[k] == 64 {
 			if result != 0 {
@@ -542,3 +545,625 @@ func TestLegalMoves(t *testing.T) {
 		}
 	}
 }
+
+// Captures and quiets must partition the legal moves.
+func TestCapturesAndQuiets(t *testing.T) {
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		walkPositions(&b, 2, func(b *Board) {
+			seen := make(map[Move]int)
+			for _, m := range b.GenerateLegalMoves() {
+				seen[m]++
+			}
+			for _, m := range b.GenerateLegalCaptures() {
+				if !IsCapture(m, b) && m.Promote() != Queen {
+					t.Error("Non-capture", &m, "generated as a capture in position\n", b.ToFen())
+				}
+				seen[m]--
+			}
+			for _, m := range b.GenerateLegalQuiets() {
+				if IsCapture(m, b) || m.Promote() == Queen {
+					t.Error("Capture", &m, "generated as a quiet move in position\n", b.ToFen())
+				}
+				seen[m]--
+			}
+			for m, count := range seen {
+				if count != 0 {
+					t.Error("Captures and quiets disagree with legal moves on", &m, "in position\n", b.ToFen())
+				}
+			}
+		})
+	}
+}
+
+// Compares the quiet checks and givesCheck against applying each move.
+func TestQuietChecks(t *testing.T) {
+	positions := append([]string{
+		"4k3/8/8/8/8/8/8/R3K2R w KQ - 0 1",   // checks by castling
+		"2k5/8/8/8/8/8/3N4/3RK3 w - - 0 1",   // discovered checks
+		"7k/3P4/8/8/3K4/8/8/8 w - - 0 1",     // underpromotion checks
+		"8/3P4/8/8/3k4/8/8/3K4 w - - 0 1",    // promotion checks through the vacated square
+		"8/8/8/2k5/3Pp3/8/8/K3R3 b - d3 0 1", // en passant
+		"1k6/8/8/4pP2/8/8/8/K3Q3 w - e6 0 1", // en passant discovered check
+		"rnbqkbnr/ppp2ppp/8/3pp3/4P3/5N2/PPPP1PPP/RNBQKB1R w KQkq d6 0 3",
+	}, perftSuitePositions...)
+	for _, fen := range positions {
+		b := ParseFen(fen)
+		walkPositions(&b, 2, func(b *Board) {
+			ci := b.computeCheckInfo()
+			expected := make(map[Move]bool)
+			for _, m := range b.GenerateLegalMoves() {
+				unapply := b.Apply(m)
+				check := b.OurKingInCheck()
+				unapply()
+				if check != b.givesCheck(m, &ci) {
+					t.Error("Gives check: wrong result", !check, "for move", &m, "in position\n", b.ToFen())
+				}
+				if check && !IsCapture(m, b) && m.Promote() != Queen {
+					expected[m] = true
+				}
+			}
+			quietChecks := b.GenerateQuietChecks()
+			for _, m := range quietChecks {
+				if !expected[m] {
+					t.Error("Quiet checks: unexpected move", &m, "in position\n", b.ToFen())
+				}
+			}
+			if len(quietChecks) != len(expected) {
+				t.Error("Quiet checks: expected", len(expected), "moves but got", len(quietChecks),
+					"in position\n", b.ToFen())
+			}
+		})
+	}
+}
+
+func TestGenerateLegalMovesIntoAllocs(t *testing.T) {
+	positions := append([]string{
+		"1k6/8/8/4pP2/8/8/8/K3Q3 w - e6 0 1",                              // en passant
+		"rnbq1bnr/pppppkpp/5p2/8/2B5/4PQ2/PPPP1PPP/RNB1K1NR b KQkq - 0 0", // check
+		"4k3/8/8/8/8/5n2/4q3/4K3 w - - 0 1",                               // double check
+		"3Q4/1Q4Q1/4Q3/2Q4R/Q4Q2/3Q4/1Q4Rp/1K1BBNNk w - - 0 1",            // 218 moves
+	}, perftSuitePositions...)
+	var ml MoveList
+	for _, fen := range positions {
+		b := ParseFen(fen)
+		allocs := testing.AllocsPerRun(100, func() {
+			b.GenerateLegalMovesInto(&ml)
+		})
+		if allocs != 0 {
+			t.Error("GenerateLegalMovesInto allocated", allocs, "times for position\n", fen)
+		}
+		expected := b.GenerateLegalMoves()
+		if ml.Len != len(expected) {
+			t.Error("GenerateLegalMovesInto: expected", len(expected), "moves but got", ml.Len)
+			continue
+		}
+		for i, m := range ml.Slice() {
+			if m != expected[i] {
+				t.Error("GenerateLegalMovesInto: move", i, "was", &m, "instead of", &expected[i])
+			}
+		}
+	}
+}
+
+// Tests every possible Move value against the generated move lists.
+func TestIsLegal(t *testing.T) {
+	positions := append([]string{
+		"1k6/8/8/K2pP2r/8/8/8/8 w - d6 0 1",  // en passant exposes the king
+		"1k6/8/8/3pP3/8/8/8/K7 w - d6 0 1",   // en passant
+		"4k3/8/8/8/8/8/8/R3K2R w KQ - 0 1",   // castling
+		"4k3/8/8/8/8/8/3r4/R3K2R w KQ - 0 1", // castling through check
+		"4k3/8/8/8/8/8/8/RN2K1NR w KQ - 0 1", // castling blocked
+		"4k3/8/8/8/8/8/8/4K2r w - - 0 1",     // king can't retreat along the checking ray
+		"r3k2r/8/8/8/8/8/8/4K3 b kq - 0 1",   // black castling
+		"4k3/1P6/8/8/8/8/8/4K3 w - - 0 1",    // promotions
+		"4k3/8/8/8/8/8/8/4K3 w KQkq - 0 1",   // castling rights without rooks
+		"1b5k/8/8/3pP3/8/8/7K/8 w - d6 0 1",  // en passant along a pin
+		"8/7k/8/8/3Pp3/8/8/1B5K b - d3 0 1",  // en passant along a pin, for black
+
+		"2k5/8/8/8/8/8/8/r3K3[QNp] w - - 0 1", // Crazyhouse drops that block a check
+	}, perftSuitePositions...)
+	variants := map[string]Variant{"2k5/8/8/8/8/8/8/r3K3[QNp] w - - 0 1": Crazyhouse}
+	for _, fen := range positions {
+		b := ParseFenVariant(fen, variants[fen])
+		walkPositions(&b, 1, func(b *Board) {
+			legal := make(map[Move]bool)
+			for _, m := range b.GenerateLegalMoves() {
+				legal[m] = true
+			}
+			pseudoLegal := make(map[Move]bool)
+			for _, m := range b.GeneratePseudoLegalMoves() {
+				pseudoLegal[m] = true
+			}
+			for i := 0; i < 1<<16; i++ {
+				m := Move(i)
+				if b.IsLegal(m) != legal[m] {
+					t.Error("IsLegal returned", !legal[m], "for move", &m, "in position\n", b.ToFen())
+				}
+				if b.IsPseudoLegal(m) != pseudoLegal[m] {
+					t.Error("IsPseudoLegal returned", !pseudoLegal[m], "for move", &m, "in position\n", b.ToFen())
+				}
+				if legal[m] && !pseudoLegal[m] {
+					t.Error("Legal move", &m, "is not pseudo-legal in position\n", b.ToFen())
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateLegalMovesFrom(t *testing.T) {
+	positions := append([]string{
+		"4k3/8/8/8/8/8/3r4/R3K2R w KQ - 0 1",                              // castling
+		"rnbq1bnr/pppppkpp/5p2/8/2B5/4PQ2/PPPP1PPP/RNB1K1NR b KQkq - 0 0", // pinned while in check
+		"4k3/8/8/8/8/5n2/4q3/4K3 w - - 0 1",                               // double check
+	}, perftSuitePositions...)
+	for _, fen := range positions {
+		b := ParseFen(fen)
+		walkPositions(&b, 2, func(b *Board) {
+			legal := b.GenerateLegalMoves()
+			for s := Square(0); s < 64; s++ {
+				var expected []Move
+				var expectedDestinations uint64
+				for _, m := range legal {
+					if Square(m.From()) == s {
+						expected = append(expected, m)
+						expectedDestinations |= uint64(1) << m.To()
+					}
+				}
+				moves := b.GenerateLegalMovesFrom(s)
+				if len(moves) != len(expected) {
+					t.Error("Moves from", IndexToAlgebraic(s), ": expected", len(expected), "but got",
+						len(moves), "in position\n", b.ToFen())
+					continue
+				}
+				for i := range moves {
+					if moves[i] != expected[i] {
+						t.Error("Moves from", IndexToAlgebraic(s), ": got", &moves[i], "instead of",
+							&expected[i], "in position\n", b.ToFen())
+					}
+				}
+				if b.LegalDestinations(s) != expectedDestinations {
+					t.Error("Wrong legal destinations from", IndexToAlgebraic(s), "in position\n", b.ToFen())
+				}
+			}
+		})
+	}
+}
+
+// Generates moves from shared boards on many goroutines; run with -race to detect writes.
+func TestConcurrentMoveGeneration(t *testing.T) {
+	fens := append([]string{
+		"rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3",
+		"8/8/8/KPpP3r/8/8/8/7k w - c6 0 1",
+		"4k3/8/8/8/8/8/4r3/R3K2R w KQ - 0 1",
+	}, perftSuitePositions...)
+	boards := make([]Board, len(fens))
+	expected := make([][]Move, len(fens))
+	for i, fen := range fens {
+		boards[i] = ParseFen(fen)
+		expected[i] = boards[i].GenerateLegalMoves()
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for iter := 0; iter < 20; iter++ {
+				for i := range boards {
+					b := &boards[i]
+					moves := b.GenerateLegalMoves()
+					if len(moves) != len(expected[i]) {
+						t.Error("Concurrent generation found", len(moves), "moves instead of",
+							len(expected[i]), "for", fens[i])
+						return
+					}
+					for _, m := range expected[i] {
+						if !b.IsLegal(m) {
+							t.Error("Concurrent IsLegal rejected", &m, "for", fens[i])
+							return
+						}
+					}
+					b.GenerateLegalCaptures()
+					b.OurKingInCheck()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for i, fen := range fens {
+		original := ParseFen(fen)
+		if boards[i] != original {
+			t.Error("Move generation modified the board", fen)
+		}
+	}
+}
+
+func TestCountLegalMoves(t *testing.T) {
+	fens := append([]string{
+		"8/8/8/KPpP3r/8/8/8/7k w - c6 0 1",
+		"8/8/8/1k6/3Pp3/8/8/4KQ2 b - d3 0 1",
+		"4k3/8/8/8/8/8/4r3/R3K2R w KQ - 0 1",
+		"4k3/8/8/8/8/5n2/8/R3K2R w KQ - 0 1",
+		"3rk3/1P6/8/8/8/8/8/4K3 w - - 0 1",
+		"1b5k/8/8/3pP3/8/8/7K/8 w - d6 0 1",
+		"1rqbkrbn/1ppppp1p/1n6/p1N3p1/8/2P4P/PP1PPPP1/1RQBKRBN w FBfb - 0 9",
+	}, perftSuitePositions...)
+	for _, fen := range fens {
+		b := ParseFen(fen)
+		walkPositions(&b, 2, func(b *Board) {
+			if count, expected := b.CountLegalMoves(), len(b.GenerateLegalMoves()); count != expected {
+				t.Error("CountLegalMoves returned", count, "instead of", expected, "for", b.ToFen())
+			}
+		})
+	}
+}
+
+func TestChess960Castling(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		legal bool
+	}{
+		// castling is encoded as the king capturing its own rook
+		{"4k3/8/8/8/8/8/8/1RK3R1 w BG - 0 1", "c1g1", true},
+		{"4k3/8/8/8/8/8/8/1RK3R1 w BG - 0 1", "c1b1", true},
+		{"4k3/8/8/8/8/8/8/1RK3R1 w BG - 0 1", "c1e1", false},
+		{"4k3/8/8/8/8/8/8/1RK3R1 w G - 0 1", "c1b1", false},
+		// the king stays on its square, but our rook no longer shields it
+		{"4k3/8/8/8/8/8/8/rRK5 w B - 0 1", "c1b1", false},
+		// the rook lands on the king's square, and the king on the rook's
+		{"4k3/8/8/8/8/8/8/5KR1 w G - 0 1", "f1g1", true},
+		// the king passes through an attacked square
+		{"4k3/8/8/8/8/8/3r4/1R2K3 w B - 0 1", "e1b1", false},
+		// only the rook passes through an attacked square
+		{"4k3/8/8/8/8/8/3r4/1RK5 w B - 0 1", "c1b1", true},
+		// a piece on the king's destination blocks castling
+		{"4k3/8/8/8/8/8/8/RK1N4 w A - 0 1", "b1a1", false},
+		{"1r2k3/8/8/8/8/8/8/1RK5 w B - 0 1", "c1b1", true},
+		// in standard encoding, castling with a standard setup is unchanged
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", true},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1h1", false},
+	}
+	for _, test := range tests {
+		b := ParseFen(test.fen)
+		m := parseMove(test.move)
+		generated := containsMove(b.GenerateLegalMoves(), m)
+		if generated != test.legal || b.IsLegal(m) != test.legal {
+			t.Error("Castling move", test.move, "should have legality", test.legal, "in position\n",
+				test.fen)
+			continue
+		}
+		if !test.legal {
+			continue
+		}
+		unapply := b.Apply(m)
+		if b.Hash() != recomputeBoardHash(&b) {
+			t.Error("Castling", test.move, "updated the hash incorrectly in position\n", test.fen)
+		}
+		if b.UnderDirectAttack(true, uint8(bits.TrailingZeros64(b.White.Kings))) {
+			t.Error("Castling", test.move, "left the king in check in position\n", test.fen)
+		}
+		unapply()
+		if original := ParseFen(test.fen); b != original {
+			t.Error("Castling", test.move, "did not unapply cleanly in position\n", test.fen)
+		}
+	}
+	b := ParseFen("4k3/8/8/8/8/8/8/5KR1 w G - 0 1")
+	b.Apply(parseMove("f1g1"))
+	if fen := b.ToFen(); fen != "4k3/8/8/8/8/8/8/5RK1 b - - 1 1" {
+		t.Error("Chess960 castling produced", fen)
+	}
+}
+
+func TestCrazyhouseDrops(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		legal bool
+	}{
+		{"4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", "N@f3", true},
+		{"4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", "B@f3", false}, // not in the pocket
+		{"4k3/8/8/8/8/8/8/4K3[n] w - - 0 1", "N@f3", false}, // in the opponent's pocket
+		{"4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", "N@e8", false}, // occupied
+		// pawns can't be dropped on the first or last rank
+		{"4k3/8/8/8/8/8/8/4K3[P] w - - 0 1", "P@a1", false},
+		{"4k3/8/8/8/8/8/8/4K3[P] w - - 0 1", "P@a8", false},
+		{"4k3/8/8/8/8/8/8/4K3[P] w - - 0 1", "P@a7", true},
+		// a drop can block a check, but not capture the checker or escape a double check
+		{"4k3/8/8/8/8/8/8/r3K3[R] w - - 0 1", "R@c1", true},
+		{"4k3/8/8/8/8/8/8/r3K3[R] w - - 0 1", "R@c2", false},
+		{"4k3/8/8/8/8/3n4/8/4K3[N] w - - 0 1", "N@d2", false},
+		{"4k3/8/8/8/8/3n4/8/r3K3[N] w - - 0 1", "N@c1", false},
+		{"4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", "N@a1", true},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Crazyhouse)
+		m := parseMove(test.move)
+		if containsMove(b.GenerateLegalMoves(), m) != test.legal || b.IsLegal(m) != test.legal {
+			t.Error("Drop", test.move, "should have legality", test.legal, "in position\n", test.fen)
+		}
+		if moves := b.GenerateLegalMoves(); len(moves) != b.CountLegalMoves() {
+			t.Error("Counted", b.CountLegalMoves(), "moves instead of", len(moves), "in position\n",
+				test.fen)
+		}
+	}
+	// every empty square is a knight drop, and the king has five moves
+	b := ParseFenVariant("4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", Crazyhouse)
+	if moves := b.GenerateLegalMoves(); len(moves) != 62+5 {
+		t.Error("Generated", len(moves), "moves instead of 67 with a knight in the pocket")
+	}
+	// drops don't exist in standard chess, even if the FEN has a pocket
+	b = ParseFen("4k3/8/8/8/8/8/8/4K3[N] w - - 0 1")
+	if m := parseMove("N@f3"); b.IsLegal(m) || containsMove(b.GenerateLegalMoves(), m) {
+		t.Error("Drop", &m, "is legal in standard chess")
+	}
+}
+
+func TestAtomicMoves(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		legal bool
+	}{
+		// kings can't capture, since they would explode
+		{"4k3/8/8/8/8/8/4p3/4K3 w - - 0 1", "e1e2", false},
+		// a capture must not explode our own king
+		{"4k3/8/8/8/8/8/3p4/3QK3 w - - 0 1", "d1d2", false},
+		{"4k3/8/8/3p4/8/8/8/3QK3 w - - 0 1", "d1d5", true},
+		// exploding the opponent king wins, even when in check
+		{"4k3/4p3/8/8/1B6/8/8/r3K3 w - - 0 1", "b4e7", true},
+		{"4k3/4p3/8/8/1B6/8/8/r3K3 w - - 0 1", "b4c5", false},
+		// touching kings can't be in check
+		{"8/8/8/8/8/8/3k4/r3K3 w - - 0 1", "e1d1", true},
+		{"8/8/8/8/8/8/3k4/r3K3 w - - 0 1", "e1f1", false},
+		{"8/8/8/8/8/8/3k4/r3K3 w - - 0 1", "e1e2", true},
+		// a check can be answered by exploding the checking piece
+		{"4k3/8/8/8/8/3N4/1p6/r3K3 w - - 0 1", "d3b2", true},
+		{"4k3/8/8/8/8/3N4/1p6/r3K3 w - - 0 1", "d3c1", true},
+		{"4k3/8/8/8/8/3N4/1p6/r3K3 w - - 0 1", "d3f4", false},
+		// the explosion doesn't reach the rook, which then gives check
+		{"4k3/8/8/8/8/1p6/8/r1N1K3 w - - 0 1", "c1b3", false},
+		// en passant explodes the capturing pawn on the en passant square
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", true},
+		// once a king has exploded, the game is over
+		{"8/8/8/8/8/8/8/r3K3 w - - 0 1", "e1e2", false},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Atomic)
+		m := parseMove(test.move)
+		moves := b.GenerateLegalMoves()
+		if containsMove(moves, m) != test.legal || b.IsLegal(m) != test.legal {
+			t.Error("Atomic move", test.move, "should have legality", test.legal, "in position\n",
+				test.fen)
+		}
+		if len(moves) != b.CountLegalMoves() {
+			t.Error("Counted", b.CountLegalMoves(), "moves instead of", len(moves), "in position\n",
+				test.fen)
+		}
+	}
+	b := ParseFenVariant("8/8/8/8/8/8/3k4/r3K3 w - - 0 1", Atomic)
+	if b.OurKingInCheck() {
+		t.Error("A king touching the opponent king should not be in check")
+	}
+	b = ParseFenVariant("8/8/8/8/8/8/8/r3K3 w - - 0 1", Atomic)
+	if _, err := b.ApplyChecked(parseMove("e1e2")); err != ErrGameOver {
+		t.Error("Moving after a king exploded should fail with ErrGameOver, not", err)
+	}
+}
+
+// Compares givesCheck against applying each move, for captures and quiet moves in Atomic.
+func TestAtomicGivesCheck(t *testing.T) {
+	for _, fen := range []string{
+		Startpos,
+		"4k3/4p3/8/8/1B6/8/8/r3K3 w - - 0 1", // exploding the king
+		"4k3/8/8/8/8/1p6/8/r1N1K3 w - - 0 1", // explosions uncovering checks
+		"8/8/8/2k5/3Pp3/8/8/K3R3 b - d3 0 1", // en passant
+		"8/8/8/8/8/8/2k5/rR4KR w KQ - 0 1",   // castling next to the opponent king
+		"8/8/8/8/8/8/3k4/r1KR3R w - - 0 1",   // a king leaving the opponent king uncovers checks
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 0",
+	} {
+		b := ParseFenVariant(fen, Atomic)
+		walkPositions(&b, 2, func(b *Board) {
+			ci := b.computeCheckInfo()
+			for _, m := range b.GenerateLegalMoves() {
+				unapply := b.Apply(m)
+				check := b.OurKingInCheck()
+				unapply()
+				if check != b.givesCheck(m, &ci) {
+					t.Error("Gives check: wrong result", !check, "for Atomic move", &m, "in position\n",
+						b.ToFen())
+				}
+			}
+		})
+	}
+}
+
+func TestVariantResult(t *testing.T) {
+	tests := []struct {
+		fen     string
+		variant Variant
+		result  Result
+	}{
+		{Startpos, Standard, NoResult},
+		{Startpos, KingOfTheHill, NoResult},
+		{"4k3/8/8/8/3K4/8/8/8 b - - 0 1", KingOfTheHill, WhiteWins},
+		{"8/8/8/4k3/8/8/8/K7 w - - 0 1", KingOfTheHill, BlackWins},
+		{"4k3/8/8/8/3K4/8/8/8 b - - 0 1", Standard, NoResult},
+		{Startpos + " +3+0", ThreeCheck, WhiteWins},
+		{Startpos + " +2+3", ThreeCheck, BlackWins},
+		{Startpos + " +2+2", ThreeCheck, NoResult},
+		{"4k3/8/8/8/8/8/8/8 w - - 0 1", Atomic, BlackWins},
+		{"8/8/8/8/8/8/8/4K3 b - - 0 1", Atomic, WhiteWins},
+		// in Antichess, a side without pieces or moves wins
+		{"8/8/8/8/8/8/8/4K3 b - - 0 1", Antichess, BlackWins},
+		{"8/8/8/8/8/p7/P7/8 w - - 0 1", Antichess, WhiteWins},
+		{"8/8/8/8/8/p7/P7/8 b - - 0 1", Antichess, BlackWins},
+		{"8/8/8/8/8/8/P7/8 b - - 0 1", Antichess, BlackWins},
+		{HordeStartpos, Horde, NoResult},
+		{"4k3/8/8/8/8/8/8/8 w - - 0 1", Horde, BlackWins},
+		// in Racing Kings, black gets one more move to reach the 8th rank too
+		{RacingKingsStartpos, RacingKings, NoResult},
+		{"6k1/8/8/8/8/8/8/K7 w - - 0 1", RacingKings, BlackWins},
+		{"K5k1/8/8/8/8/8/8/8 w - - 0 1", RacingKings, Draw},
+		{"K7/6k1/8/8/8/8/8/8 b - - 0 1", RacingKings, NoResult},
+		{"K7/6k1/8/8/8/8/8/8 w - - 0 1", RacingKings, WhiteWins},
+		{"K7/8/8/8/8/8/8/7k b - - 0 1", RacingKings, WhiteWins},
+		{"K7/7k/8/8/8/2B5/8/6R1 b - - 0 1", RacingKings, WhiteWins},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, test.variant)
+		if result := b.VariantResult(); result != test.result {
+			t.Error("Expected result", test.result, "but got", result, "in position\n", test.fen)
+		}
+		if test.result == NoResult {
+			continue
+		}
+		// once the game is over, no moves are legal
+		if len(b.GenerateLegalMoves()) != 0 || b.CountLegalMoves() != 0 {
+			t.Error("Generated moves after the game ended in position\n", test.fen)
+		}
+		if _, err := b.ApplyChecked(parseMove("a1a2")); err == nil {
+			t.Error("Moving after the game ended should fail in position\n", test.fen)
+		}
+	}
+}
+
+func TestAntichessMoves(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		legal bool
+	}{
+		// captures are compulsory, and there is no check
+		{"4k3/8/8/8/8/8/4p3/4K3 w - - 0 1", "e1e2", true},
+		{"4k3/8/8/8/8/8/4p3/4K3 w - - 0 1", "e1d1", false},
+		{"4k3/8/8/8/8/8/4p3/R3K3 w - - 0 1", "a1a8", false},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 0 1", "a1a8", true},
+		{"4k3/8/8/8/8/8/8/R3K3 w - - 0 1", "a1e1", false},
+		{"4k3/8/8/8/8/8/8/4K3 w - - 0 1", "e1e2", true},
+		// kings can be captured
+		{"8/8/8/8/8/8/4k3/R3K3 w - - 0 1", "e1e2", true},
+		{"8/8/8/8/8/8/4k3/R3K3 w - - 0 1", "a1a2", false},
+		// en passant is a capture too
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", true},
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e1e2", false},
+		// pawns can promote to kings
+		{"8/P7/8/8/8/8/8/4k3 w - - 0 1", "a7a8k", true},
+		{"8/P7/8/8/8/8/8/4k3 w - - 0 1", "a7a8q", true},
+		// there is no castling
+		{"r3k2r/8/8/8/8/8/8/R3K2R w - - 0 1", "e1g1", false},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Antichess)
+		m := parseMove(test.move)
+		moves := b.GenerateLegalMoves()
+		if containsMove(moves, m) != test.legal || b.IsLegal(m) != test.legal {
+			t.Error("Antichess move", test.move, "should have legality", test.legal, "in position\n",
+				test.fen)
+		}
+		if len(moves) != b.CountLegalMoves() {
+			t.Error("Counted", b.CountLegalMoves(), "moves instead of", len(moves), "in position\n",
+				test.fen)
+		}
+	}
+	b := ParseFenVariant("4k3/8/8/8/8/8/4p3/4K3 w - - 0 1", Antichess)
+	if _, err := b.ApplyChecked(parseMove("e1d1")); err != ErrMustCapture {
+		t.Error("Declining a capture should fail with ErrMustCapture, not", err)
+	}
+	b = ParseFenVariant(Startpos, Antichess)
+	if fen := b.ToFen(); fen != "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1" {
+		t.Error("The Antichess starting position should have no castling rights, but was", fen)
+	}
+}
+
+func TestKinglessMoves(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		legal bool
+	}{
+		// a side without a king can never be in check, and ignores its castling rights
+		{"4k3/8/8/8/8/8/8/P2R4 w K - 0 1", "d1d8", true},
+		{"4k3/8/8/8/8/8/8/P2R4 w K - 0 1", "a1a2", true},
+		{"4k3/8/8/8/8/8/8/P2R4 w K - 0 1", "a1a3", true},
+		// but its opponent can be
+		{"4k3/8/8/8/8/8/8/4R3 b - - 0 1", "e8d8", true},
+		{"4k3/8/8/8/8/8/8/4R3 b - - 0 1", "e8e7", false},
+		// Horde pawns on the first and second ranks can move two squares
+		{HordeStartpos, "a4a5", true},
+		{HordeStartpos, "a4a6", false},
+		{"k7/5p2/4p2P/3p2P1/2p2P2/1p2P2P/p2P2P1/2P2P2 w - - 0 1", "c1c3", true},
+		{"k7/5p2/4p2P/3p2P1/2p2P2/1p2P2P/p2P2P1/2P2P2 w - - 0 1", "d2d4", true},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Horde)
+		m := parseMove(test.move)
+		moves := b.GenerateLegalMoves()
+		if containsMove(moves, m) != test.legal || b.IsLegal(m) != test.legal {
+			t.Error("Move", test.move, "should have legality", test.legal, "in position\n", test.fen)
+		}
+		if len(moves) != b.CountLegalMoves() {
+			t.Error("Counted", b.CountLegalMoves(), "moves instead of", len(moves), "in position\n",
+				test.fen)
+		}
+	}
+	b := ParseFen("4k3/8/8/8/8/8/8/P2R4 w K - 0 1")
+	if b.OurKingInCheck() {
+		t.Error("A side without a king should not be in check")
+	}
+	if fen := b.ToFen(); fen != "4k3/8/8/8/8/8/8/P2R4 w - - 0 1" {
+		t.Error("A side without a king should have no castling rights, but was", fen)
+	}
+	// a double push from the first rank does not allow en passant
+	b = ParseFenVariant("k7/5p2/4p2P/3p2P1/2p2P2/1p2P2P/p2P2P1/2P2P2 w - - 0 1", Horde)
+	b.Apply(parseMove("c1c3"))
+	if b.IsLegal(parseMove("b3c2")) {
+		t.Error("En passant should not be legal after a double push from the first rank")
+	}
+	// outside Horde, a pawn on its first rank can only move one square
+	for fen, move := range map[string]string{
+		"4k3/8/8/8/8/8/8/P3K3 w - - 0 1": "a1a3",
+		"4k2p/8/8/8/8/8/8/4K3 b - - 0 1": "h8h6",
+	} {
+		b = ParseFen(fen)
+		if containsMove(b.GenerateLegalMoves(), parseMove(move)) || b.IsLegal(parseMove(move)) ||
+			b.CountLegalMoves() != len(b.GenerateLegalMoves()) {
+			t.Error("A pawn on its first rank should not move two squares outside Horde in position\n", fen)
+		}
+	}
+}
+
+func TestRacingKingsMoves(t *testing.T) {
+	tests := []struct {
+		fen   string
+		move  string
+		legal bool
+	}{
+		// no move may give check, directly or by discovery
+		{"8/8/8/8/8/k7/8/K6R w - - 0 1", "h1h3", false},
+		{"8/8/8/8/8/k7/8/K6R w - - 0 1", "h1h2", true},
+		{"8/8/8/8/8/k7/8/K6R w - - 0 1", "h1b1", true},
+		{"8/8/8/8/k7/8/N7/R6K w - - 0 1", "a2c1", false},
+		{"8/8/8/8/k7/8/N7/R6K w - - 0 1", "h1g1", true},
+		// kings still can't move into check
+		{"8/8/8/8/8/k7/8/K6R b - - 0 1", "a3b3", true},
+		{"8/8/8/8/8/k7/8/K6R b - - 0 1", "a3a2", false},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, RacingKings)
+		m := parseMove(test.move)
+		moves := b.GenerateLegalMoves()
+		if containsMove(moves, m) != test.legal || b.IsLegal(m) != test.legal {
+			t.Error("Racing Kings move", test.move, "should have legality", test.legal,
+				"in position\n", test.fen)
+		}
+		if len(moves) != b.CountLegalMoves() {
+			t.Error("Counted", b.CountLegalMoves(), "moves instead of", len(moves), "in position\n",
+				test.fen)
+		}
+	}
+	b := ParseFenVariant("8/8/8/8/8/k7/8/K6R w - - 0 1", RacingKings)
+	if _, err := b.ApplyChecked(parseMove("h1h3")); err != ErrGivesCheck {
+		t.Error("Giving check should fail with ErrGivesCheck, not", err)
+	}
+}
@@ -0,0 +1,130 @@
+package dragontoothmg
+
+// The stages of the move picker, in the order that moves are returned.
+const (
+	stageHashMove = iota
+	stageCaptures
+	stageKillers
+	stageQuiets
+	stageDone
+)
+
+// A MovePicker lazily generates the legal moves of a position in stages, in the order
+// that is most useful to an alpha-beta search:
+// the hash move, captures (most valuable victim, least valuable attacker first),
+// killer moves, and finally the remaining quiet moves.
+// If the search cuts off early, the later stages are never generated.
+// Every legal move is returned exactly once. The hash and killer moves are validated with
+// IsLegal(), so that no moves need to be generated before they are returned; if they are
+// illegal, they are ignored.
+// The board may be changed between calls to Next(), but it must be restored to the
+// original position before Next() is called again.
+type MovePicker struct {
+	b             *Board
+	hashMove      Move
+	killers       [2]Move
+	stage         int
+	captures      ExtMoveList
+	captureScores [kMaxMoveListLength]int
+	quiets        MoveList
+	capturesReady bool
+	index         int
+	killerIndex   int
+}
+
+// Creates a move picker for the position on b. A hashMove or killers of 0 are ignored.
+func NewMovePicker(b *Board, hashMove Move, killers [2]Move) MovePicker {
+	return MovePicker{b: b, hashMove: hashMove, killers: killers}
+}
+
+// Returns the next legal move. Returns false once every legal move has been returned.
+func (mp *MovePicker) Next() (Move, bool) {
+	for {
+		switch mp.stage {
+		case stageHashMove:
+			mp.stage = stageCaptures
+			if mp.hashMove != 0 && mp.b.IsLegal(mp.hashMove) {
+				return mp.hashMove, true
+			}
+		case stageCaptures:
+			mp.generateCaptures()
+			if mp.index < mp.captures.Len {
+				// Selection sort; we only pay for the captures that are actually searched.
+				best := mp.index
+				for i := mp.index + 1; i < mp.captures.Len; i++ {
+					if mp.captureScores[i] > mp.captureScores[best] {
+						best = i
+					}
+				}
+				mp.captures.Moves[mp.index], mp.captures.Moves[best] = mp.captures.Moves[best], mp.captures.Moves[mp.index]
+				mp.captureScores[mp.index], mp.captureScores[best] =
+					mp.captureScores[best], mp.captureScores[mp.index]
+				move := mp.captures.Moves[mp.index].Move()
+				mp.index++
+				if move == mp.hashMove {
+					continue
+				}
+				return move, true
+			}
+			mp.stage = stageKillers
+			mp.index = 0
+		case stageKillers:
+			for mp.killerIndex < len(mp.killers) {
+				killer := mp.killers[mp.killerIndex]
+				mp.killerIndex++
+				if killer == 0 || killer == mp.hashMove ||
+					(mp.killerIndex == 2 && killer == mp.killers[0]) {
+					continue
+				}
+				// Killers that are captures were already returned with the captures
+				if !mp.isCaptureStageMove(killer) && mp.b.IsLegal(killer) {
+					return killer, true
+				}
+			}
+			mp.stage = stageQuiets
+			mp.generateQuiets()
+		case stageQuiets:
+			for mp.index < mp.quiets.Len {
+				move := mp.quiets.Moves[mp.index]
+				mp.index++
+				if move == mp.hashMove || move == mp.killers[0] || move == mp.killers[1] {
+					continue
+				}
+				return move, true
+			}
+			mp.stage = stageDone
+		default:
+			return 0, false
+		}
+	}
+}
+
+// Whether the move would be generated in the captures stage.
+func (mp *MovePicker) isCaptureStageMove(m Move) bool {
+	return IsCapture(m, mp.b) || m.Promote() == Queen
+}
+
+func (mp *MovePicker) generateCaptures() {
+	if mp.capturesReady {
+		return
+	}
+	mp.capturesReady = true
+	mp.b.generateLegalMoves(mp.captures.sink(), genCaptures, everything)
+	for i, move := range mp.captures.Slice() {
+		mp.captureScores[i] = mvvLvaScore(move)
+	}
+}
+
+func (mp *MovePicker) generateQuiets() {
+	mp.b.generateLegalMoves(mp.quiets.sink(), genQuiets, everything)
+}
+
+// Scores a capture by most valuable victim, then least valuable attacker.
+// Promotions are scored by the material they gain.
+func mvvLvaScore(e ExtMove) int {
+	score := int(e.Captured())*8 - int(e.Piece())
+	if m := e.Move(); m.Promote() != Nothing {
+		score += (int(m.Promote()) - Pawn) * 8
+	}
+	return score
+}
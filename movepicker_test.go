@@ -0,0 +1,104 @@
+package dragontoothmg
+
+import (
+	"testing"
+)
+
+// Perft using a move picker. The hash and killer moves are taken from earlier nodes at
+// the same depth, so they are frequently illegal in the current position.
+func pickerPerft(b *Board, n int, hashMoves []Move, killers [][2]Move) int64 {
+	if n <= 0 {
+		return 1
+	}
+	mp := NewMovePicker(b, hashMoves[n], killers[n])
+	var count int64 = 0
+	for {
+		move, ok := mp.Next()
+		if !ok {
+			break
+		}
+		unapply := b.Apply(move)
+		count += pickerPerft(b, n-1, hashMoves, killers)
+		unapply()
+		if IsCapture(move, b) {
+			hashMoves[n] = move
+		} else {
+			killers[n][1], killers[n][0] = killers[n][0], move
+		}
+	}
+	return count
+}
+
+func TestMovePickerPerft(t *testing.T) {
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		expected := Perft(&b, 3)
+		result := pickerPerft(&b, 3, make([]Move, 4), make([][2]Move, 4))
+		if result != expected {
+			t.Error("Move picker perft error in position\n", fen, "\nExpected", expected, "but got", result)
+		}
+		if b.ToFen() != fen {
+			t.Error("Move picker corrupted board state.")
+		}
+	}
+}
+
+// The union of all stages must be exactly the legal moves, whatever the hash and killer moves.
+func TestMovePickerStages(t *testing.T) {
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		walkPositions(&b, 2, func(b *Board) {
+			legal := b.GenerateLegalMoves()
+			candidates := append([]Move{0, parseMove("e2e4"), parseMove("a1a8"), parseMove("e8g8")}, legal...)
+			for i, hashMove := range candidates {
+				killers := [2]Move{candidates[(i+1)%len(candidates)], candidates[(i+2)%len(candidates)]}
+				seen := make(map[Move]int)
+				for _, m := range legal {
+					seen[m]++
+				}
+				mp := NewMovePicker(b, hashMove, killers)
+				var moves []Move
+				for {
+					move, ok := mp.Next()
+					if !ok {
+						break
+					}
+					moves = append(moves, move)
+					seen[move]--
+				}
+				for m, count := range seen {
+					if count != 0 {
+						t.Error("Move picker returned", &m, count, "too few times in position\n", b.ToFen())
+					}
+				}
+				if containsMove(legal, hashMove) && moves[0] != hashMove {
+					t.Error("Move picker didn't return the hash move first in position\n", b.ToFen())
+				}
+			}
+		})
+	}
+}
+
+func TestMovePickerOrder(t *testing.T) {
+	// The rook on d5 can be taken by a pawn, knight or queen; the pawn on g4 by a knight or queen.
+	// The second killer move is illegal.
+	b := ParseFen("4k3/8/2q5/3r4/2P3p1/4N3/6Q1/4K3 w - - 0 1")
+	killer := parseMove("e1f2")
+	mp := NewMovePicker(&b, parseMove("e1f1"), [2]Move{killer, parseMove("g2g8")})
+	expected := []string{"e1f1", "c4d5", "e3d5", "g2d5", "e3g4", "g2g4", "e1f2"}
+	for _, v := range expected {
+		move, ok := mp.Next()
+		if !ok || move.String() != v {
+			t.Error("Move picker order: expected", v, "but got", &move)
+		}
+	}
+}
+
+func containsMove(moves []Move, m Move) bool {
+	for _, v := range moves {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
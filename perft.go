@@ -1,6 +1,11 @@
 package dragontoothmg
 
-import "fmt"
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
 
 // Run perft to count the number of moves.
 // Useful for testing and benchmarking.
@@ -8,19 +13,48 @@ func Perft(b *Board, n int) int64 {
 	if n <= 0 {
 		return 1
 	}
-	moves := b.GenerateLegalMoves()
-	if n == 1 {
-		return int64(len(moves))
+	if n == 1 { // the leaves only need to be counted
+		return int64(b.CountLegalMoves())
 	}
+	var moves ExtMoveList
+	b.GenerateLegalExtMovesInto(&moves)
 	var count int64 = 0
-	for _, move := range moves {
-		unapply := b.Apply(move)
+	var undo UndoInfo
+	for _, move := range moves.Slice() {
+		b.makeExtMove(move, &undo)
 		count += Perft(b, n-1)
-		unapply()
+		b.UnmakeMove(move.Move(), &undo)
 	}
 	return int64(count)
 }
 
+// Runs perft with the moves at the root divided between goroutines, one for each CPU.
+// Each goroutine searches its own copy of the board, made with WithMove, so b is unchanged.
+func PerftParallel(b *Board, n int) int64 {
+	if n <= 1 {
+		return Perft(b, n)
+	}
+	moves := make(chan Move)
+	var count int64
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for move := range moves {
+				child := b.WithMove(move)
+				atomic.AddInt64(&count, Perft(&child, n-1))
+			}
+		}()
+	}
+	for _, move := range b.GenerateLegalMoves() {
+		moves <- move
+	}
+	close(moves)
+	wg.Wait()
+	return count
+}
+
 // Performs the Perft move count division operation. Useful for debugging.
 func Divide(b *Board, n int) {
 	moves := b.GenerateLegalMoves()
@@ -118,8 +118,175 @@ func TestPromotions(t *testing.T) {
 	checkPerftResults(pos, perftSolutions, t)
 }
 
+// Positions from the published Chess960 perft suite, in Shredder-FEN.
+func TestChess960Positions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9": {
+			1: 21, 2: 528, 3: 12189, 4: 326672},
+		"2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9": {
+			1: 21, 2: 807, 3: 18002},
+		"b1q1rrkb/pppppppp/3nn3/8/P7/1PPP4/4PPPP/BQNNRKRB w GE - 1 9": {
+			1: 20, 2: 479, 3: 10471, 4: 273318},
+		"qbbnnrkr/2pp2pp/p7/1p2pp2/8/P3PP2/1PPP1KPP/QBBNNR1R w hf - 0 9": {
+			1: 22, 2: 593, 3: 13440, 4: 382958},
+		"1nbbnrkr/p1p1ppp1/3p4/1p3P1p/3Pq2P/8/PPP1P1P1/QNBBNRKR w HFhf - 0 9": {
+			1: 28, 2: 1120, 3: 31058, 4: 1171749},
+		"1rqbkrbn/1ppppp1p/1n6/p1N3p1/8/2P4P/PP1PPPP1/1RQBKRBN w FBfb - 0 9": {
+			1: 29, 2: 502, 3: 14569, 4: 287739},
+	}
+	for fen, perftSolutions := range positions {
+		checkPerftResults(fen, perftSolutions, t)
+	}
+	// The standard starting position is also a Chess960 position
+	b := ParseFenChess960(Startpos)
+	if result := Perft(&b, 4); result != 197281 {
+		t.Error("Chess960 perft of the starting position was", result, "instead of 197281")
+	}
+}
+
+func TestCrazyhousePositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		// an empty pocket is enough to play Crazyhouse
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1": {
+			1: 20, 2: 400, 3: 8902, 4: 197281, 5: 4888832},
+		"2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1": {
+			1: 301, 2: 75353},
+		"r1bqk2r/pppp1ppp/2n1p3/4P3/1b1Pn3/2NB1N2/PPP2PPP/R1BQK2R[] b KQkq - 0 1": {
+			1: 42, 2: 1347, 3: 58057},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, Crazyhouse), perftSolutions, t)
+	}
+	// the pockets and promoted pieces must be hashed and serialized correctly throughout the tree
+	for _, fen := range []string{"2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1",
+		"r3k2r/1P4P1/8/8/8/8/1p4p1/R3K2R[NPp] w KQkq - 0 1"} {
+		b := ParseFenVariant(fen, Crazyhouse)
+		walkPositions(&b, 2, func(b *Board) {
+			if b.Hash() != recomputeBoardHash(b) {
+				t.Error("Incorrect hash in Crazyhouse position\n", b.ToFen())
+			}
+			if reparsed := ParseFenVariant(b.ToFen(), Crazyhouse); reparsed != *b {
+				t.Error("Crazyhouse position did not survive a FEN round trip\n", b.ToFen())
+			}
+		})
+	}
+}
+
+func TestAtomicPositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		Startpos: {1: 20, 2: 400, 3: 8902, 4: 197326, 5: 4864979},
+		// castling next to the opponent king, where the destination can't be attacked
+		"8/8/8/8/8/8/2k5/rR4KR w KQ - 0 1":      {1: 18, 2: 180, 3: 4364},
+		"r3k1rR/5K2/8/8/8/8/8/8 b kq - 0 1":     {1: 25, 2: 282, 3: 6753},
+		"Rr2k1rR/3K4/3p4/8/8/8/7P/8 w kq - 0 1": {1: 21, 2: 465, 3: 10631},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, Atomic), perftSolutions, t)
+	}
+	// the exploded pieces must be hashed and restored correctly throughout the tree
+	for _, fen := range []string{Startpos, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 0"} {
+		b := ParseFenVariant(fen, Atomic)
+		walkPositions(&b, 3, func(b *Board) {
+			if b.Hash() != recomputeBoardHash(b) {
+				t.Error("Incorrect hash in Atomic position\n", b.ToFen())
+			}
+		})
+		if original := ParseFenVariant(fen, Atomic); b != original {
+			t.Error("Atomic moves did not unapply cleanly in position\n", fen)
+		}
+	}
+}
+
+func TestThreeCheckPositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 3+3 0 1": {
+			1: 20, 2: 400, 3: 8902, 4: 197281},
+		// a single check wins
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 1+1 0 1": {
+			1: 48, 2: 2039, 3: 97848, 4: 4081798},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, ThreeCheck), perftSolutions, t)
+	}
+	// the checks must be hashed and serialized correctly throughout the tree
+	b := ParseFenVariant("r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1 +1+1", ThreeCheck)
+	walkPositions(&b, 3, func(b *Board) {
+		if b.Hash() != recomputeBoardHash(b) {
+			t.Error("Incorrect hash in Three-check position\n", b.ToFen())
+		}
+		if reparsed := ParseFenVariant(b.ToFen(), ThreeCheck); reparsed != *b {
+			t.Error("Three-check position did not survive a FEN round trip\n", b.ToFen())
+		}
+	})
+}
+
+func TestKingOfTheHillPositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		Startpos: {1: 20, 2: 400, 3: 8902, 4: 197281},
+		// the game ends when the king reaches d4, so black has no reply
+		"8/8/8/8/8/2K5/8/k7 w - - 0 1": {1: 7, 2: 14},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, KingOfTheHill), perftSolutions, t)
+	}
+}
+
+func TestAntichessPositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		Startpos:                       {1: 20, 2: 400, 3: 8067, 4: 153299, 5: 2732672},
+		"8/1p6/8/8/8/8/P7/8 w - - 0 1": {1: 2, 2: 4, 3: 4, 4: 3, 5: 1},
+		// pawns can promote to kings, and the first promoted piece on a8 must capture on h1
+		"8/P7/8/8/8/8/7p/8 w - - 0 1": {1: 5, 2: 25},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, Antichess), perftSolutions, t)
+	}
+	b := ParseFenVariant("8/P7/8/8/8/8/7p/8 w - - 0 1", Antichess)
+	walkPositions(&b, 4, func(b *Board) {
+		if b.Hash() != recomputeBoardHash(b) {
+			t.Error("Incorrect hash in Antichess position\n", b.ToFen())
+		}
+	})
+}
+
+func TestHordePositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		HordeStartpos: {1: 8, 2: 128, 3: 1274, 4: 23310, 5: 265223},
+		// white pawns double pushing from the first rank
+		"k7/5p2/4p2P/3p2P1/2p2P2/1p2P2P/p2P2P1/2P2P2 w - - 0 1": {1: 13, 2: 172, 3: 2205, 4: 33781},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, Horde), perftSolutions, t)
+	}
+	b := ParseFenVariant(HordeStartpos, Horde)
+	walkPositions(&b, 3, func(b *Board) {
+		if b.Hash() != recomputeBoardHash(b) || ParseFenVariant(b.ToFen(), Horde) != *b {
+			t.Error("Incorrect hash or FEN in Horde position\n", b.ToFen())
+		}
+	})
+}
+
+func TestRacingKingsPositions(t *testing.T) {
+	positions := map[string]map[int]int64{
+		RacingKingsStartpos:                 {1: 21, 2: 421, 3: 11264, 4: 296242},
+		"4brn1/2K2k2/8/8/8/8/8/8 w - - 0 1": {1: 6, 2: 33, 3: 178, 4: 3151},
+	}
+	for fen, perftSolutions := range positions {
+		checkBoardPerftResults(ParseFenVariant(fen, RacingKings), perftSolutions, t)
+	}
+	b := ParseFenVariant(RacingKingsStartpos, RacingKings)
+	walkPositions(&b, 3, func(b *Board) {
+		if b.Hash() != recomputeBoardHash(b) {
+			t.Error("Incorrect hash in Racing Kings position\n", b.ToFen())
+		}
+	})
+}
+
 func checkPerftResults(fen string, perftSolutions map[int]int64, t *testing.T) {
-	b := ParseFen(fen)
+	checkBoardPerftResults(ParseFen(fen), perftSolutions, t)
+}
+
+func checkBoardPerftResults(b Board, perftSolutions map[int]int64, t *testing.T) {
 	for i := 1; i <= len(perftSolutions); i++ {
 		beforeFen := b.ToFen()
 		result := Perft(&b, i)
@@ -133,3 +300,89 @@ func checkPerftResults(fen string, perftSolutions map[int]int64, t *testing.T) {
 		}
 	}
 }
+
+// The positions from the perft tests above, for tests that compare generators against each other.
+var perftSuitePositions = []string{
+	Startpos,
+	"5k1R/5p2/5P2/8/8/2r5/2rR2K1/4B3 b - - 0 1",
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 0",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 0",
+	"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+	"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+	"r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 w - - 0 10",
+	"n1n5/PPPk4/8/8/8/8/4Kppp/5N1N b - - 0 1",
+}
+
+// Calls visit on every position reachable from b in at most depth plies (including b itself).
+func walkPositions(b *Board, depth int, visit func(*Board)) {
+	visit(b)
+	if depth <= 0 {
+		return
+	}
+	for _, move := range b.GenerateLegalMoves() {
+		unapply := b.Apply(move)
+		walkPositions(b, depth-1, visit)
+		unapply()
+	}
+}
+
+// Perft using WithMove, which copies the board instead of unapplying moves.
+func perftCopyMake(b *Board, n int) int64 {
+	if n <= 1 {
+		return Perft(b, n)
+	}
+	var count int64
+	for _, move := range b.GenerateLegalMoves() {
+		child := b.WithMove(move)
+		count += perftCopyMake(&child, n-1)
+	}
+	return count
+}
+
+func TestWithMove(t *testing.T) {
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		for _, move := range b.GenerateLegalMoves() {
+			child := b.WithMove(move)
+			if b != ParseFen(fen) {
+				t.Error("WithMove changed the original board in position\n", fen)
+			}
+			b.Apply(move)
+			if child != b {
+				t.Error("WithMove and Apply gave different boards for", &move, "in position\n", fen)
+			}
+			b = ParseFen(fen)
+		}
+		if copied, applied := perftCopyMake(&b, 3), Perft(&b, 3); copied != applied {
+			t.Error("Perft with copy-make counted", copied, "instead of", applied, "in position\n", fen)
+		}
+	}
+}
+
+func TestPerftParallel(t *testing.T) {
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		for depth := 0; depth <= 3; depth++ {
+			if parallel, serial := PerftParallel(&b, depth), Perft(&b, depth); parallel != serial {
+				t.Error("Parallel perft counted", parallel, "instead of", serial, "at depth", depth,
+					"in position\n", fen)
+			}
+		}
+		if b != ParseFen(fen) {
+			t.Error("Parallel perft changed the board in position\n", fen)
+		}
+	}
+}
+
+// Counting the leaves of the tree must not allocate.
+func TestPerftLeafAllocs(t *testing.T) {
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		allocs := testing.AllocsPerRun(100, func() {
+			Perft(&b, 1)
+		})
+		if allocs != 0 {
+			t.Error("Perft at depth 1 allocated", allocs, "times for position\n", fen)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package dragontoothmg
+
+import "math/bits"
+
+// Piece values and piece-square tables for evaluation, for the midgame and the endgame.
+// Tables are indexed by piece type (Pawn to King; Nothing is unused) and square, from white's
+// point of view: a black piece on a square is scored like a white piece on the vertically
+// mirrored square.
+type PieceSquareTable struct {
+	Material [2][7]int32     // for the midgame and endgame, the value of each piece type
+	Squares  [2][7][64]int32 // for the midgame and endgame, the bonus of each piece on each square
+}
+
+// The indices of the midgame and endgame values in a PieceSquareTable and a Score.
+const (
+	Midgame = 0
+	Endgame = 1
+)
+
+// The material and piece-square totals of a position, for the midgame and endgame, as white's
+// total minus black's. Pieces in a Crazyhouse pocket are not counted.
+type Score struct {
+	Material [2]int32
+	Squares  [2]int32
+}
+
+// Sets the table that the board's score is accumulated from, and computes the score. Once
+// set, the score is updated incrementally as moves are applied and unapplied, so an evaluation
+// doesn't need to loop over the pieces. A nil table turns the accumulators off.
+func (b *Board) SetPieceSquareTable(t *PieceSquareTable) {
+	b.pst = t
+	b.score = b.RecomputeScore()
+}
+
+// Returns the accumulated score. It is zero unless a table was set with SetPieceSquareTable.
+func (b *Board) Score() Score {
+	return b.score
+}
+
+// Computes the score from scratch, by looping over every piece on the board. This is slow,
+// but useful to verify the accumulated score while debugging.
+func (b *Board) RecomputeScore() Score {
+	var s Score
+	if b.pst == nil {
+		return s
+	}
+	for p := Piece(Pawn); p <= King; p++ {
+		for pieces := *pieceBitboard(&(b.White), p); pieces != 0; pieces &= pieces - 1 {
+			b.pst.addPiece(&s, true, p, uint8(bits.TrailingZeros64(pieces)), 1)
+		}
+		for pieces := *pieceBitboard(&(b.Black), p); pieces != 0; pieces &= pieces - 1 {
+			b.pst.addPiece(&s, false, p, uint8(bits.TrailingZeros64(pieces)), 1)
+		}
+	}
+	return s
+}
+
+// Whether the accumulated score matches a full recomputation. For debugging.
+func (b *Board) ScoreIsConsistent() bool {
+	return b.score == b.RecomputeScore()
+}
+
+// Adds a piece on a square to the accumulated score, or with sign -1, removes it.
+func (b *Board) scorePiece(white bool, p Piece, square uint8, sign int32) {
+	if b.pst != nil {
+		b.pst.addPiece(&b.score, white, p, square, sign)
+	}
+}
+
+// Adds the value of a piece on a square to s, or with sign -1, subtracts it.
+func (t *PieceSquareTable) addPiece(s *Score, white bool, p Piece, square uint8, sign int32) {
+	if !white {
+		square ^= 56 // mirror the square vertically
+		sign = -sign
+	}
+	s.Material[Midgame] += sign * t.Material[Midgame][p]
+	s.Material[Endgame] += sign * t.Material[Endgame][p]
+	s.Squares[Midgame] += sign * t.Squares[Midgame][p][square]
+	s.Squares[Endgame] += sign * t.Squares[Endgame][p][square]
+}
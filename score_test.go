@@ -0,0 +1,67 @@
+package dragontoothmg
+
+import (
+	"testing"
+)
+
+// A table where every piece and square has a different value, so that any missed update is
+// detected.
+func testPieceSquareTable() *PieceSquareTable {
+	var t PieceSquareTable
+	for phase := Midgame; phase <= Endgame; phase++ {
+		for p := Pawn; p <= King; p++ {
+			t.Material[phase][p] = int32(100*p + 7*phase)
+			for square := 0; square < 64; square++ {
+				t.Squares[phase][p][square] = int32(square*(p+1) + 13*phase)
+			}
+		}
+	}
+	return &t
+}
+
+func TestScore(t *testing.T) {
+	table := testPieceSquareTable()
+	b := ParseFen(Startpos)
+	if b.Score() != (Score{}) {
+		t.Error("The score should be zero without a table, but was", b.Score())
+	}
+	b.SetPieceSquareTable(table)
+	if b.Score() != (Score{}) {
+		t.Error("The symmetric starting position should score zero, but scored", b.Score())
+	}
+	// the knights on e5 and e4 cancel out, leaving white a knight on c1 up
+	b = ParseFen("4k3/8/8/4N3/4n3/8/8/2N1K3 w - - 0 1")
+	b.SetPieceSquareTable(table)
+	expected := Score{
+		Material: [2]int32{200, 200 + 7},
+		Squares:  [2]int32{2 * 3, 2*3 + 13},
+	}
+	if b.Score() != expected {
+		t.Error("Expected the score", expected, "but got", b.Score())
+	}
+}
+
+func TestScoreIsConsistent(t *testing.T) {
+	boards := []Board{
+		ParseFenChess960("1r2k1r1/8/8/8/8/8/8/1R2K1R1 w GBgb - 0 1"),
+		ParseFenVariant("2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1", Crazyhouse),
+		ParseFenVariant("r3k1rR/5K2/8/8/8/8/8/8 b kq - 0 1", Atomic),
+		ParseFenVariant("8/P7/8/8/8/8/7p/8 w - - 0 1", Antichess),
+	}
+	for _, fen := range perftSuitePositions {
+		boards = append(boards, ParseFen(fen))
+	}
+	for _, b := range boards {
+		b.SetPieceSquareTable(testPieceSquareTable())
+		original := b
+		walkPositions(&b, 3, func(b *Board) {
+			if !b.ScoreIsConsistent() {
+				t.Error("Expected the score", b.RecomputeScore(), "but got", b.Score(), "in position\n",
+					b.ToFen())
+			}
+		})
+		if b != original {
+			t.Error("Unapplying the moves did not restore the score in position\n", b.ToFen())
+		}
+	}
+}
@@ -23,6 +23,130 @@ type Board struct {
 	White         Bitboards
 	Black         Bitboards
 	hash          uint64
+	pawnHash      uint64   // the hash of the pawns and kings only
+	materialHash  uint64   // the hash of the number of pieces of each type
+	chess960      bool     // castling moves are encoded as the king capturing its own rook
+	castleRooks   [4]uint8 // the square of the rook for each castling right, in castlerights order
+	variant       Variant
+	pockets       [2][5]uint8 // Crazyhouse: for white and black, the number of pawns to queens in hand
+	promoted      uint64      // Crazyhouse: the pieces that were promoted from pawns
+	checks        [2]uint8    // Three-check: the number of checks given by white and black
+
+	pst   *PieceSquareTable // if set, the table that score is accumulated from
+	score Score
+}
+
+// The chess variant that a board is playing.
+type Variant uint8
+
+const (
+	Standard      Variant = iota
+	Crazyhouse            // captured pieces can be dropped back onto the board
+	Atomic                // captures explode every piece but pawns around the destination
+	ThreeCheck            // giving a third check wins
+	KingOfTheHill         // moving the king to the center (d4, e4, d5 or e5) wins
+	Antichess             // captures are compulsory, and losing every piece wins
+	Horde                 // white has only pawns and no king, and loses when they are all captured
+	RacingKings           // no move may give check, and the first king to reach the 8th rank wins
+)
+
+// The result of a game.
+type Result uint8
+
+const (
+	NoResult Result = iota // the game is not over
+	WhiteWins
+	BlackWins
+	Draw
+)
+
+// Returns the result of the game if it has ended by a rule of the variant, such as an exploded
+// king, a third check, or a king on the hill. Otherwise (and always in standard chess), returns
+// NoResult. Once the game has ended, there are no legal moves.
+// Checkmate and stalemate are not detected here; they are positions without legal moves.
+// In Antichess, however, a side that can't move wins, so this is detected.
+func (b *Board) VariantResult() Result {
+	result := b.variantRuleResult()
+	if result == NoResult && b.variant == Antichess && b.CountLegalMoves() == 0 {
+		if b.Wtomove {
+			return WhiteWins
+		}
+		return BlackWins
+	}
+	return result
+}
+
+// Returns the result of a game that has ended by a rule of the variant, without checking
+// whether the side to move has any moves.
+func (b *Board) variantRuleResult() Result {
+	switch b.variant {
+	case Atomic:
+		if b.White.Kings == 0 {
+			return BlackWins
+		} else if b.Black.Kings == 0 {
+			return WhiteWins
+		}
+	case ThreeCheck:
+		if b.checks[0] >= kChecksToWin {
+			return WhiteWins
+		} else if b.checks[1] >= kChecksToWin {
+			return BlackWins
+		}
+	case KingOfTheHill:
+		if b.White.Kings&centerSquares != 0 {
+			return WhiteWins
+		} else if b.Black.Kings&centerSquares != 0 {
+			return BlackWins
+		}
+	case Horde:
+		if b.White.All == 0 {
+			return BlackWins
+		}
+	case RacingKings:
+		whiteArrived := b.White.Kings&onlyRank[7] != 0
+		blackArrived := b.Black.Kings&onlyRank[7] != 0
+		if whiteArrived && blackArrived {
+			return Draw
+		} else if blackArrived {
+			return BlackWins
+		} else if whiteArrived && (b.Wtomove || !b.racingKingsBlackCanEqualize()) {
+			// black moves second, so it gets one more move to reach the 8th rank and draw
+			return WhiteWins
+		}
+	case Antichess:
+		if b.White.All == 0 {
+			return WhiteWins
+		} else if b.Black.All == 0 {
+			return BlackWins
+		}
+	}
+	return NoResult
+}
+
+// Returns the variant that the board is playing.
+func (b *Board) Variant() Variant {
+	return b.variant
+}
+
+// Returns the number of pieces of a type (Pawn to Queen) in a side's Crazyhouse pocket.
+func (b *Board) PocketCount(white bool, p Piece) int {
+	if p < Pawn || p > Queen {
+		return 0
+	}
+	return int(b.pockets[sideIndex(white)][p-1])
+}
+
+// Returns the number of checks a side still has to give to win a Three-check game.
+func (b *Board) RemainingChecks(white bool) int {
+	return kChecksToWin - int(b.checks[sideIndex(white)])
+}
+
+// The index of a side in the per-side arrays of Board, such as Board.pockets.
+func sideIndex(white bool) int {
+	if white {
+		return 0
+	}
+	return 1
 }
 
 // Return the Zobrist hash value for the board.
@@ -34,6 +158,20 @@ func (b *Board) Hash() uint64 {
 	return b.hash
 }
 
+// Return the Zobrist hash of the pawns and kings on the board, for a pawn structure hash
+// table. Like Hash(), it is incrementally updated.
+func (b *Board) PawnHash() uint64 {
+	return b.pawnHash
+}
+
+// Return a hash of the number of pieces of each type and color on the board (excluding any
+// Crazyhouse pockets), for looking up material-specific evaluations, such as endgames.
+// Positions with the same material have the same hash, wherever the pieces are.
+// Like Hash(), it is incrementally updated.
+func (b *Board) MaterialHash() uint64 {
+	return b.materialHash
+}
+
 // Castle rights helpers. Data stored inside, from LSB:
 // 1 bit: White castle queenside
 // 1 bit: White castle kingside
@@ -42,6 +180,34 @@ func (b *Board) Hash() uint64 {
 // This just indicates whether castling rights have been lost, not whether
 // castling is actually possible.
 
+// The square of the rook we castle with on the given side.
+func (b *Board) castlingRook(kingside bool) uint8 {
+	return b.castleRooks[castleRightIndex(b.Wtomove, kingside)]
+}
+
+// The square of the rook the opponent castles with on the given side.
+func (b *Board) oppCastlingRook(kingside bool) uint8 {
+	return b.castleRooks[castleRightIndex(!b.Wtomove, kingside)]
+}
+
+// The index of a castling right in castlerights and castleRooks.
+func castleRightIndex(white bool, kingside bool) int {
+	index := 0
+	if !white {
+		index += 2
+	}
+	if kingside {
+		index++
+	}
+	return index
+}
+
+// Whether the board uses Chess960 (Fischer Random) castling rules.
+// In Chess960, castling moves are written as the king capturing its own rook, e.g. "b1a1".
+func (b *Board) Chess960() bool {
+	return b.chess960
+}
+
 // Castling helper functions for all 16 possible scenarios
 func (b *Board) whiteCanCastleQueenside() bool {
 	return b.castlerights&1 == 1
@@ -142,7 +308,8 @@ type Bitboards struct {
 // Data stored inside, from LSB
 // 6 bits: destination square
 // 6 bits: source square
-// 3 bits: promotion
+// 3 bits: promotion, or the dropped piece for a drop
+// 1 bit: drop (Crazyhouse only)
 
 // Move bitwise structure; internal implementation is private.
 type Move uint16
@@ -156,6 +323,18 @@ func (m *Move) From() uint8 {
 
 // Whether the move involves promoting a pawn.
 func (m *Move) Promote() Piece {
+	if *m&0x8000 != 0 { // drops don't promote
+		return Nothing
+	}
+	return Piece((*m & 0x7000) >> 12)
+}
+
+// The piece dropped from the pocket onto the destination square, or Nothing if the move is
+// not a Crazyhouse drop.
+func (m *Move) Drop() Piece {
+	if *m&0x8000 == 0 {
+		return Nothing
+	}
 	return Piece((*m & 0x7000) >> 12)
 }
 func (m *Move) Setto(s Square) *Move {
@@ -167,7 +346,13 @@ func (m *Move) Setfrom(s Square) *Move {
 	return m
 }
 func (m *Move) Setpromote(p Piece) *Move {
-	*m = *m & ^(Move(0x7000)) | (Move(p) << 12)
+	*m = *m & ^(Move(0xF000)) | (Move(p) << 12)
+	return m
+}
+
+// Turns the move into a drop of the given piece. The source square is ignored.
+func (m *Move) Setdrop(p Piece) *Move {
+	*m = *m & ^(Move(0xFFC0)) | (Move(p) << 12) | 0x8000
 	return m
 }
 func (m *Move) String() string {
@@ -176,6 +361,9 @@ func (m *Move) String() string {
 	if *m == 0 {
 		return "0000"
 	}
+	if drop := m.Drop(); drop != Nothing {
+		return string("PNBRQ"[drop-1]) + "@" + IndexToAlgebraic(Square(m.To()))
+	}
 	result := IndexToAlgebraic(Square(m.From())) + IndexToAlgebraic(Square(m.To()))
 	switch m.Promote() {
 	case Queen:
@@ -186,11 +374,119 @@ func (m *Move) String() string {
 		result += "r"
 	case Bishop:
 		result += "b"
+	case King:
+		result += "k"
 	default:
 	}
 	return result
 }
 
+// Data stored inside, from LSB
+// 16 bits: the Move
+// 3 bits: the moving piece
+// 3 bits: the captured piece (a pawn for en passant captures)
+// 1 bit: castling
+// 1 bit: en passant capture
+
+// A move with extra information about the position it is played in, so that the piece types
+// and the kind of move don't need to be looked up on the board. Only valid for the position
+// it was generated in.
+type ExtMove uint32
+
+// Converts the extended move back to a plain Move.
+func (e *ExtMove) Move() Move {
+	return Move(*e & 0xFFFF)
+}
+
+func (e *ExtMove) To() uint8 {
+	return uint8(*e & 0x3F)
+}
+func (e *ExtMove) From() uint8 {
+	return uint8((*e & 0xFC0) >> 6)
+}
+
+// The type of the moving piece.
+func (e *ExtMove) Piece() Piece {
+	return Piece((*e >> 16) & 0x7)
+}
+
+// The type of the captured piece, or Nothing.
+func (e *ExtMove) Captured() Piece {
+	return Piece((*e >> 19) & 0x7)
+}
+func (e *ExtMove) IsCapture() bool {
+	return e.Captured() != Nothing
+}
+func (e *ExtMove) IsCastle() bool {
+	return *e&(1<<22) != 0
+}
+func (e *ExtMove) IsEnPassant() bool {
+	return *e&(1<<23) != 0
+}
+func (e *ExtMove) String() string {
+	m := e.Move()
+	return m.String()
+}
+
+// A fixed-capacity list of moves, which can be filled by the move generator without any
+// heap allocations. The zero value is an empty list, and a list can be reused many times.
+type MoveList struct {
+	Moves [kMaxMoveListLength]Move
+	Len   int
+}
+
+// Returns the moves in the list. The slice shares storage with the list, so it is
+// only valid until the list is filled again.
+func (ml *MoveList) Slice() []Move {
+	return ml.Moves[:ml.Len]
+}
+
+// A fixed-capacity list of extended moves, which the move generator fills directly, without
+// any heap allocations. Like a MoveList, the zero value is an empty list.
+type ExtMoveList struct {
+	Moves [kMaxMoveListLength]ExtMove
+	Len   int
+}
+
+// Returns the moves in the list. The slice shares storage with the list, so it is
+// only valid until the list is filled again.
+func (ml *ExtMoveList) Slice() []ExtMove {
+	return ml.Moves[:ml.Len]
+}
+
+// The destination of the moves found by the move generator. Each move is added to ext with
+// its extended information if ext is set, or otherwise to moves as a plain Move, so that a
+// MoveList is filled directly, without going through an ExtMoveList.
+type moveSink struct {
+	ext   *ExtMoveList
+	moves *MoveList
+}
+
+// Returns a sink that adds the plain moves to the list.
+func (ml *MoveList) sink() *moveSink {
+	return &moveSink{moves: ml}
+}
+
+// Returns a sink that adds the extended moves to the list.
+func (ml *ExtMoveList) sink() *moveSink {
+	return &moveSink{ext: ml}
+}
+
+func (s *moveSink) add(e ExtMove) {
+	if s.ext != nil {
+		s.ext.Moves[s.ext.Len] = e
+		s.ext.Len++
+	} else {
+		s.moves.Moves[s.moves.Len] = e.Move()
+		s.moves.Len++
+	}
+}
+
+// Adds a move of a piece, which captures the given piece type, or Nothing.
+func (s *moveSink) addMove(m Move, piece Piece, captured Piece) {
+	s.add(ExtMove(m) | ExtMove(piece)<<16 | ExtMove(captured)<<19)
+}
+
 // Square index values from 0-63.
 type Square uint8
 
@@ -206,3 +502,12 @@ const (
 	Queen   = iota
 	King    = iota
 )
+
+// Selects which kinds of moves the move generator produces.
+type genMode uint8
+
+const (
+	genQuiets   genMode = 1 << iota // non-captures, castling, and underpromoting pawn pushes
+	genCaptures                     // captures, en passant, and queen promotions
+	genAll      = genQuiets | genCaptures
+)
@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/bits"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 func recomputeBoardHash(b *Board) uint64 {
@@ -26,6 +28,16 @@ func recomputeBoardHash(b *Board) uint64 {
 		hash ^= castleRightsZobristC[3]
 	}
 	hash ^= uint64(b.enpassant)
+	for side := 0; side < 2; side++ {
+		for piece := 0; piece < 5; piece++ {
+			for count := 0; count < int(b.pockets[side][piece]); count++ {
+				hash ^= pocketZobristC[side][piece][count]
+			}
+		}
+		for count := 0; count < int(b.checks[side]); count++ {
+			hash ^= checksZobristC[side][count]
+		}
+	}
 	for i := uint8(0); i < 64; i++ {
 		whitePiece, _ := determinePieceType(&(b.White), uint64(1)<<i)
 		blackPiece, _ := determinePieceType(&(b.Black), uint64(1)<<i)
@@ -39,15 +51,46 @@ func recomputeBoardHash(b *Board) uint64 {
 	return hash
 }
 
+// Computes the pawn hash from scratch, from the pawns and kings of both sides.
+func recomputePawnHash(b *Board) uint64 {
+	var hash uint64
+	for pieces := b.White.Pawns | b.White.Kings; pieces != 0; pieces &= pieces - 1 {
+		square := bits.TrailingZeros64(pieces)
+		piece, _ := determinePieceType(&(b.White), uint64(1)<<uint8(square))
+		hash ^= pieceSquareZobristC[piece-1][square]
+	}
+	for pieces := b.Black.Pawns | b.Black.Kings; pieces != 0; pieces &= pieces - 1 {
+		square := bits.TrailingZeros64(pieces)
+		piece, _ := determinePieceType(&(b.Black), uint64(1)<<uint8(square))
+		hash ^= pieceSquareZobristC[piece+5][square]
+	}
+	return hash
+}
+
+// Computes the material hash from scratch: the sum of a key for every piece on the board.
+func recomputeMaterialHash(b *Board) uint64 {
+	var hash uint64
+	for p := Piece(Pawn); p <= King; p++ {
+		hash += uint64(bits.OnesCount64(*pieceBitboard(&(b.White), p))) * materialZobristC[p-1]
+		hash += uint64(bits.OnesCount64(*pieceBitboard(&(b.Black), p))) * materialZobristC[p+5]
+	}
+	return hash
+}
+
 func IsCapture(m Move, b *Board) bool {
+	if m.Drop() != Nothing {
+		return false
+	}
 	toBitboard := (uint64(1) << m.To())
-	if (toBitboard&b.White.All != 0) || (toBitboard&b.Black.All != 0) {
+	// Only the opponent's pieces can be captured (in Chess960, the king "captures" its own
+	// rook to castle)
+	if (b.Wtomove && toBitboard&b.Black.All != 0) || (!b.Wtomove && toBitboard&b.White.All != 0) {
 		return true
 	}
 	// Is it an en passant capture?
 	fromBitboard := (uint64(1) << m.From())
 	originIsPawn := fromBitboard&b.White.Pawns != 0 || fromBitboard&b.Black.Pawns != 0
-	return originIsPawn && (toBitboard&(uint64(1)<<b.enpassant) != 0)
+	return originIsPawn && b.enpassant != 0 && m.To() == b.enpassant
 }
 
 func GetPieceType(square uint8, b *Board) (int, bool) {
@@ -103,12 +146,23 @@ func (b *Bitboards) sanityCheck() {
 
 // Some example valid move strings:
 // e1e2 b4d6 e7e8q a2a1n
+// In Chess960, castling is written as the king capturing its own rook, e.g. e1h1 or b8a8.
+// Crazyhouse drops are written as the piece, "@", and the destination, e.g. N@f3 or P@e4.
 // TODO(dylhunn): Make the parser more forgiving. Eg: 0-0, O-O-O, a2-a3, D3D4
 func ParseMove(movestr string) (Move, error) {
 	if movestr == "0000" {
 		return 0, nil
 	}
 	var mv Move
+	if len(movestr) == 4 && movestr[1] == '@' {
+		piece := strings.IndexByte("PNBRQ", strings.ToUpper(movestr)[0]) + 1
+		to, err := AlgebraicToIndex(movestr[2:4])
+		if piece == 0 || err != nil {
+			return mv, errors.New("Invalid drop to parse.")
+		}
+		mv.Setto(Square(to)).Setdrop(Piece(piece))
+		return mv, nil
+	}
 	if len(movestr) < 4 || len(movestr) > 5 {
 		return mv, errors.New("Invalid move to parse.")
 	}
@@ -128,6 +182,8 @@ func ParseMove(movestr string) (Move, error) {
 			mv.Setpromote(Queen)
 		case 'r':
 			mv.Setpromote(Rook)
+		case 'k':
+			mv.Setpromote(King)
 		default:
 			return mv, errors.New("Invalid promotion symbol in move.")
 		}
@@ -187,7 +243,8 @@ func IndexToAlgebraic(id Square) string {
 	return fmt.Sprintf("%c", rune) + strconv.Itoa((int(id)/8)+1)
 }
 
-// Serializes a board position to a Fen string.
+// Serializes a board position to a Fen string. The castling rights of a Chess960 board are
+// written in Shredder-FEN format, e.g. "HAha".
 func (b *Board) ToFen() string {
 	b.White.sanityCheck()
 	b.Black.sanityCheck()
@@ -227,6 +284,9 @@ func (b *Board) ToFen() string {
 		} else {
 			empty++
 		}
+		if b.promoted&currMask != 0 {
+			toprint += "~"
+		}
 		if toprint != "" {
 			if empty != 0 {
 				position += strconv.Itoa(empty)
@@ -245,6 +305,9 @@ func (b *Board) ToFen() string {
 			}
 		}
 	}
+	if b.variant == Crazyhouse {
+		position += "[" + b.pocketFen(true) + b.pocketFen(false) + "]"
+	}
 	if b.Wtomove {
 		position += " w"
 	} else {
@@ -253,19 +316,19 @@ func (b *Board) ToFen() string {
 	position += " "
 	castleCount := 0
 	if b.whiteCanCastleKingside() {
-		position += "K"
+		position += b.castlingRightFen(true, true)
 		castleCount++
 	}
 	if b.whiteCanCastleQueenside() {
-		position += "Q"
+		position += b.castlingRightFen(true, false)
 		castleCount++
 	}
 	if b.blackCanCastleKingside() {
-		position += "k"
+		position += b.castlingRightFen(false, true)
 		castleCount++
 	}
 	if b.blackCanCastleQueenside() {
-		position += "q"
+		position += b.castlingRightFen(false, false)
 		castleCount++
 	}
 	if castleCount == 0 {
@@ -278,14 +341,208 @@ func (b *Board) ToFen() string {
 		position += "-"
 	}
 	position = position + " " + strconv.Itoa(int(b.Halfmoveclock)) + " " + strconv.Itoa(int(b.Fullmoveno))
+	if b.variant == ThreeCheck { // the checks given by each side
+		position += " +" + strconv.Itoa(int(b.checks[0])) + "+" + strconv.Itoa(int(b.checks[1]))
+	}
 	return position
 }
 
+// Parses the Three-check counts for white and black, such as "1+0". The counts are either of
+// the checks given, or of the checks remaining.
+func (b *Board) parseChecks(counts string, remaining bool) {
+	for side, count := range strings.SplitN(counts, "+", 2) {
+		checks, _ := strconv.Atoi(count)
+		if remaining {
+			checks = kChecksToWin - checks
+		}
+		if checks < 0 {
+			checks = 0
+		} else if checks > kChecksToWin {
+			checks = kChecksToWin
+		}
+		b.checks[side] = uint8(checks)
+	}
+}
+
+// Parses a Crazyhouse pocket such as "QNnpp". Returns false if the pockets could come to hold
+// more than kMaxPocketCount pieces of a type, counting the pieces on the board that can be
+// captured into them. Promoted pieces count as pawns, since they are demoted when captured.
+func (b *Board) parsePocket(pocket string) bool {
+	for _, c := range pocket {
+		piece := strings.IndexRune("PNBRQ", unicode.ToUpper(c)) + 1
+		side := sideIndex(unicode.IsUpper(c))
+		if piece == 0 {
+			continue
+		}
+		if b.pockets[side][piece-1] == kMaxPocketCount {
+			return false
+		}
+		b.pockets[side][piece-1]++
+	}
+	onBoard := [5]uint64{
+		b.White.Pawns | b.Black.Pawns | b.promoted,
+		(b.White.Knights | b.Black.Knights) &^ b.promoted,
+		(b.White.Bishops | b.Black.Bishops) &^ b.promoted,
+		(b.White.Rooks | b.Black.Rooks) &^ b.promoted,
+		(b.White.Queens | b.Black.Queens) &^ b.promoted,
+	}
+	for i, pieces := range onBoard {
+		if bits.OnesCount64(pieces)+int(b.pockets[0][i])+int(b.pockets[1][i]) > kMaxPocketCount {
+			return false
+		}
+	}
+	return true
+}
+
+// Serializes a side's Crazyhouse pocket, from queens to pawns. Lowercase is used for black.
+func (b *Board) pocketFen(white bool) string {
+	var pocket string
+	for p := Piece(Queen); p >= Pawn; p-- {
+		symbol := string("PNBRQ"[p-1])
+		if !white {
+			symbol = strings.ToLower(symbol)
+		}
+		pocket += strings.Repeat(symbol, b.PocketCount(white, p))
+	}
+	return pocket
+}
+
+// Serializes a castling right in X-FEN format: K or Q if castling is with the outermost rook
+// on that side, or otherwise the file of the rook. Chess960 boards use Shredder-FEN format,
+// which always gives the file, so that parsing the FEN again restores Chess960 castling even
+// if the king and rooks are on their standard squares. Lowercase is used for black.
+func (b *Board) castlingRightFen(white bool, kingside bool) string {
+	rook := b.castleRooks[castleRightIndex(white, kingside)]
+	ourRooks, backRank := b.Black.Rooks, uint8(56)
+	if white {
+		ourRooks, backRank = b.White.Rooks, 0
+	}
+	corner, symbol := backRank, "Q"
+	if kingside {
+		corner, symbol = backRank+7, "K"
+	}
+	if b.chess960 || ourRooks&squaresBetweenInclusive(rook, corner)&^(uint64(1)<<rook) != 0 {
+		symbol = string(rune('A' + rook%8))
+	}
+	if !white {
+		symbol = strings.ToLower(symbol)
+	}
+	return symbol
+}
+
+// Grants the castling right described by one character of a FEN castling field.
+// K, Q, k and q refer to the outermost rook on that side (as in X-FEN), and file letters to
+// the rook on that file (as in Shredder-FEN). Returns false if the character is invalid.
+// Sets chess960 if the right can't occur in standard chess.
+func (b *Board) parseCastlingRight(c byte) bool {
+	white := c >= 'A' && c <= 'Z'
+	ourBitboards, backRank := &(b.Black), uint8(56)
+	if white {
+		ourBitboards, backRank = &(b.White), 0
+	}
+	if ourBitboards.Kings == 0 { // only a king can castle
+		return false
+	}
+	king := backRank + 4
+	if kings := ourBitboards.Kings & onlyRank[backRank/8]; kings != 0 {
+		king = uint8(bits.TrailingZeros64(kings))
+	}
+	var rook uint8
+	var kingside bool
+	switch lower := c | 0x20; {
+	case lower == 'k': // the outermost rook, or the corner if there is none
+		kingside = true
+		for rook = backRank + 7; rook > king; rook-- {
+			if ourBitboards.Rooks&(uint64(1)<<rook) != 0 {
+				break
+			}
+		}
+		if rook == king {
+			rook = backRank + 7
+		}
+	case lower == 'q':
+		for rook = backRank; rook < king; rook++ {
+			if ourBitboards.Rooks&(uint64(1)<<rook) != 0 {
+				break
+			}
+		}
+		if rook == king {
+			rook = backRank
+		}
+	case lower >= 'a' && lower <= 'h':
+		rook = backRank + (lower - 'a')
+		kingside = rook > king
+		b.chess960 = true
+	default:
+		return false
+	}
+	if king != backRank+4 || (kingside && rook != backRank+7) || (!kingside && rook != backRank) {
+		b.chess960 = true
+	}
+	index := castleRightIndex(white, kingside)
+	b.castlerights |= 1 << uint(index)
+	b.castleRooks[index] = rook
+	return true
+}
+
+// Parse a board from a FEN string for the given variant.
+// For Crazyhouse, the pockets may be given in brackets after the pieces, e.g.
+// "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[Qn] w KQkq - 0 1", or as a ninth rank.
+// Promoted pieces are followed by a "~". If the pockets hold too many pieces, an empty board
+// is returned.
+// For Three-check, the checks given by white and black may follow the move numbers, e.g.
+// "... w KQkq - 0 1 +1+0", or the checks remaining may follow the en passant square, e.g.
+// "... w KQkq - 2+3 0 1".
+// In other variants, the pockets, promotions and checks are ignored.
+func ParseFenVariant(fen string, variant Variant) Board {
+	return parseFen(fen, variant)
+}
+
+// Parse a board from a FEN string for a Chess960 (Fischer Random) game.
+// Unlike ParseFen, castling moves always use Chess960 encoding (the king captures its own
+// rook), even when the king and rooks start on their standard squares.
+func ParseFenChess960(fen string) Board {
+	b := ParseFen(fen)
+	b.chess960 = true
+	return b
+}
+
 // Parse a board from a FEN string.
+// The castling field may be in standard, X-FEN or Shredder-FEN format. If the castling rights
+// can't occur in standard chess, the board uses Chess960 castling rules.
+// The board plays standard chess; use ParseFenVariant for the variants.
 func ParseFen(fen string) Board {
+	return parseFen(fen, Standard)
+}
+
+// Parse a board from a FEN string for the given variant.
+func parseFen(fen string, variant Variant) Board {
 	// BUG(dylhunn): This FEN parsing implementation doesn't handle malformed inputs.
 	tokens := strings.Fields(fen)
-	var b Board
+	b := Board{variant: variant}
+	// split off the Three-check counts, as the checks given ("+1+0") after the move numbers,
+	// or as the checks remaining ("2+3") after the en passant square
+	if last := tokens[len(tokens)-1]; len(tokens) > 4 && strings.HasPrefix(last, "+") {
+		if variant == ThreeCheck {
+			b.parseChecks(last[1:], false)
+		}
+		tokens = tokens[:len(tokens)-1]
+	} else if len(tokens) > 4 && strings.Contains(tokens[4], "+") {
+		if variant == ThreeCheck {
+			b.parseChecks(tokens[4], true)
+		}
+		tokens = append(tokens[:4], tokens[5:]...)
+	}
+	// split off the Crazyhouse pocket, in brackets or as a ninth rank
+	var pocket string
+	if start := strings.IndexByte(tokens[0], '['); start >= 0 {
+		pocket = strings.TrimSuffix(tokens[0][start+1:], "]")
+		tokens[0] = tokens[0][:start]
+	} else if strings.Count(tokens[0], "/") == 8 {
+		split := strings.LastIndexByte(tokens[0], '/')
+		pocket = tokens[0][split+1:]
+		tokens[0] = tokens[0][:split]
+	}
 	// replace digits with the appropriate number of dashes
 	for i := 1; i <= 8; i++ {
 		var replacement string
@@ -305,8 +562,15 @@ func ParseFen(fen string) Board {
 		tokens[0] += ranks[i]
 	}
 	// add every piece to the board
-	for i := uint8(0); i < 64; i++ {
-		switch tokens[0][i] {
+	i := uint8(0)
+	for j := 0; j < len(tokens[0]); j++ {
+		if tokens[0][j] == '~' { // the previous piece was promoted
+			if variant == Crazyhouse {
+				b.promoted |= 1 << (i - 1)
+			}
+			continue
+		}
+		switch tokens[0][j] {
 		case 'p':
 			b.Black.Pawns |= 1 << i
 		case 'n':
@@ -332,22 +596,22 @@ func ParseFen(fen string) Board {
 		case 'K':
 			b.White.Kings |= 1 << i
 		}
+		i++
 	}
 	b.White.All = b.White.Pawns | b.White.Knights | b.White.Bishops | b.White.Rooks | b.White.Queens | b.White.Kings
 	b.Black.All = b.Black.Pawns | b.Black.Knights | b.Black.Bishops | b.Black.Rooks | b.Black.Queens | b.Black.Kings
+	if variant == Crazyhouse && !b.parsePocket(pocket) {
+		var b2 Board
+		return b2 // too many pieces for the pockets
+	}
 
 	b.Wtomove = tokens[1] == "w" || tokens[1] == "W"
-	if strings.Contains(tokens[2], "K") {
-		b.flipWhiteKingsideCastle()
-	}
-	if strings.Contains(tokens[2], "Q") {
-		b.flipWhiteQueensideCastle()
-	}
-	if strings.Contains(tokens[2], "k") {
-		b.flipBlackKingsideCastle()
-	}
-	if strings.Contains(tokens[2], "q") {
-		b.flipBlackQueensideCastle()
+	b.castleRooks = [4]uint8{0, 7, 56, 63}
+	// there is no castling in Antichess and Racing Kings
+	if tokens[2] != "-" && variant != Antichess && variant != RacingKings {
+		for i := 0; i < len(tokens[2]); i++ {
+			b.parseCastlingRight(tokens[2][i])
+		}
 	}
 	if tokens[3] != "-" {
 		res, err := AlgebraicToIndex(tokens[3])
@@ -368,5 +632,73 @@ func ParseFen(fen string) Board {
 		b.Fullmoveno = uint16(result)
 	}
 	b.hash = recomputeBoardHash(&b)
+	b.pawnHash = recomputePawnHash(&b)
+	b.materialHash = recomputeMaterialHash(&b)
 	return b
 }
+
+// Returns the board mirrored vertically, with the colours swapped: white's pieces become
+// black's pieces on the mirrored squares, and the other side moves. The castling rights, en
+// passant square, pockets and hashes are mirrored too, so the new position is equivalent
+// (except in variants whose sides play by different rules, such as Horde).
+func (b *Board) Mirror() Board {
+	m := *b
+	m.White, m.Black = mirrorBitboards(b.Black), mirrorBitboards(b.White)
+	m.Wtomove = !b.Wtomove
+	if b.enpassant != 0 {
+		m.enpassant = b.enpassant ^ 56
+	}
+	m.castlerights = b.castlerights>>2&3 | b.castlerights&3<<2
+	for i := range m.castleRooks {
+		m.castleRooks[i] = b.castleRooks[i^2] ^ 56
+	}
+	m.pockets[0], m.pockets[1] = b.pockets[1], b.pockets[0]
+	m.checks[0], m.checks[1] = b.checks[1], b.checks[0]
+	m.promoted = bits.ReverseBytes64(b.promoted)
+	m.recomputeKeys()
+	return m
+}
+
+// Returns the board mirrored horizontally, so the a-file becomes the h-file. This is only an
+// equivalent position without castling rights, so it fails for a board that has any.
+func (b *Board) FlipHorizontal() (Board, error) {
+	if b.castlerights != 0 {
+		return *b, errors.New("A board with castling rights can't be flipped horizontally.")
+	}
+	m := *b
+	m.White, m.Black = flipBitboards(b.White), flipBitboards(b.Black)
+	if b.enpassant != 0 {
+		m.enpassant = b.enpassant ^ 7
+	}
+	m.promoted = flipBitboard(b.promoted)
+	m.recomputeKeys()
+	return m, nil
+}
+
+// Mirrors every bitboard vertically.
+func mirrorBitboards(bb Bitboards) Bitboards {
+	return Bitboards{Pawns: bits.ReverseBytes64(bb.Pawns), Knights: bits.ReverseBytes64(bb.Knights),
+		Bishops: bits.ReverseBytes64(bb.Bishops), Rooks: bits.ReverseBytes64(bb.Rooks),
+		Queens: bits.ReverseBytes64(bb.Queens), Kings: bits.ReverseBytes64(bb.Kings),
+		All: bits.ReverseBytes64(bb.All)}
+}
+
+// Mirrors every bitboard horizontally.
+func flipBitboards(bb Bitboards) Bitboards {
+	return Bitboards{Pawns: flipBitboard(bb.Pawns), Knights: flipBitboard(bb.Knights),
+		Bishops: flipBitboard(bb.Bishops), Rooks: flipBitboard(bb.Rooks),
+		Queens: flipBitboard(bb.Queens), Kings: flipBitboard(bb.Kings), All: flipBitboard(bb.All)}
+}
+
+// Mirrors a bitboard horizontally, by reversing the bits of each rank.
+func flipBitboard(bb uint64) uint64 {
+	return bits.ReverseBytes64(bits.Reverse64(bb))
+}
+
+// Recomputes the hashes and the score from scratch, after the whole board has changed.
+func (b *Board) recomputeKeys() {
+	b.hash = recomputeBoardHash(b)
+	b.pawnHash = recomputePawnHash(b)
+	b.materialHash = recomputeMaterialHash(b)
+	b.score = b.RecomputeScore()
+}
@@ -19,6 +19,11 @@ func TestParseMove(t *testing.T) {
 		move2.Promote() != Knight {
 		t.Error("Incorrectly parsed move.")
 	}
+	// Antichess allows promotion to a king
+	move3, _ := ParseMove("a2a1k")
+	if move3.Promote() != King || move3.String() != "a2a1k" {
+		t.Error("Incorrectly parsed move.")
+	}
 }
 
 func TestAlgToIdx(t *testing.T) {
@@ -116,3 +121,239 @@ func TestToFen(t *testing.T) {
 		}
 	}
 }
+
+func TestChess960Fen(t *testing.T) {
+	tests := []struct {
+		fen      string
+		xfen     string // the expected output of ToFen
+		chess960 bool
+	}{
+		{Startpos, Startpos, false},
+		// Chess960 boards are written in Shredder-FEN, even from the standard position
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w HAha - 0 1",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w HAha - 0 1", true},
+		{"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9",
+			"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", true},
+		{"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w KQkq - 2 9",
+			"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", true},
+		// X-FEN uses the file when the castling rook is not the outermost one
+		{"rr2k3/8/8/8/8/8/8/RR2K3 w Qq - 0 1", "rr2k3/8/8/8/8/8/8/RR2K3 w Qq - 0 1", false},
+		{"rr2k3/8/8/8/8/8/8/RR2K3 w Bb - 0 1", "rr2k3/8/8/8/8/8/8/RR2K3 w Bb - 0 1", true},
+	}
+	for _, test := range tests {
+		b := ParseFen(test.fen)
+		if fen := b.ToFen(); fen != test.xfen {
+			t.Error("Parsed", test.fen, "but serialized it as", fen)
+		}
+		if reparsed := ParseFen(b.ToFen()); reparsed != b {
+			t.Error("Parsing the FEN of", test.fen, "again gave a different board")
+		}
+		if b.Chess960() != test.chess960 {
+			t.Error("Chess960 should be", test.chess960, "for", test.fen)
+		}
+		if b.Hash() != recomputeBoardHash(&b) {
+			t.Error("Incorrect hash for", test.fen)
+		}
+	}
+	b := ParseFen("rr2k3/8/8/8/8/8/8/RR2K3 w Bb - 0 1")
+	if !containsMove(b.GenerateLegalMoves(), parseMove("e1b1")) ||
+		containsMove(b.GenerateLegalMoves(), parseMove("e1a1")) {
+		t.Error("Castling should use the rook on the b file")
+	}
+	// a Chess960 game from the standard position still castles onto the rook after a round trip
+	b = ParseFenChess960("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+	b = ParseFen(b.ToFen())
+	if !b.Chess960() || !containsMove(b.GenerateLegalMoves(), parseMove("e1h1")) ||
+		containsMove(b.GenerateLegalMoves(), parseMove("e1g1")) {
+		t.Error("Chess960 castling was lost by the FEN round trip", b.ToFen())
+	}
+}
+
+func TestCrazyhouseFen(t *testing.T) {
+	tests := []struct {
+		fen    string
+		zhfen  string // the expected output of ToFen
+		pocket [2][5]uint8
+	}{
+		{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1", [2][5]uint8{}},
+		{"r1bqk2r/pppp1ppp/2n1p3/4P3/1b1Pn3/2NB1N2/PPP2PPP/R1BQK2R[bNQ] b KQkq - 0 1",
+			"r1bqk2r/pppp1ppp/2n1p3/4P3/1b1Pn3/2NB1N2/PPP2PPP/R1BQK2R[QNb] b KQkq - 0 1",
+			[2][5]uint8{{0, 1, 0, 0, 1}, {0, 0, 1, 0, 0}}},
+		// the pocket may also be given as a ninth rank
+		{"2k5/8/8/8/8/8/8/4K3/QRBNPPqrbnp w - - 0 1", "2k5/8/8/8/8/8/8/4K3[QRBNPPqrbnp] w - - 0 1",
+			[2][5]uint8{{2, 1, 1, 1, 1}, {1, 1, 1, 1, 1}}},
+		// promoted pieces are marked with a tilde
+		{"2k2Q~2/8/8/8/8/8/8/q~3K3[] b - - 0 1", "2k2Q~2/8/8/8/8/8/8/q~3K3[] b - - 0 1", [2][5]uint8{}},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, Crazyhouse)
+		if fen := b.ToFen(); fen != test.zhfen {
+			t.Error("Parsed", test.fen, "but serialized it as", fen)
+		}
+		if b.Variant() != Crazyhouse || b.pockets != test.pocket {
+			t.Error("Incorrect Crazyhouse pockets for", test.fen)
+		}
+		if b.Hash() != recomputeBoardHash(&b) {
+			t.Error("Incorrect hash for", test.fen)
+		}
+	}
+	// the pockets are part of the hash
+	one := ParseFenVariant("4k3/8/8/8/8/8/8/4K3[N] w - - 0 1", Crazyhouse)
+	two := ParseFenVariant("4k3/8/8/8/8/8/8/4K3[NN] w - - 0 1", Crazyhouse)
+	if one.Hash() == two.Hash() {
+		t.Error("Positions with different pockets have the same hash")
+	}
+	// the pockets can hold all 16 pawns, but no more, counting the pawns on the board
+	if b := ParseFenVariant("4k3/8/8/8/8/8/8/4K3[PPPPPPPPpppppppp] w - - 0 1", Crazyhouse); b.PocketCount(true, Pawn) != 8 {
+		t.Error("Could not parse a Crazyhouse pocket with 8 pawns")
+	}
+	for _, fen := range []string{"4k3/8/8/8/8/8/8/4K3[PPPPPPPPPPPPPPPPP] w - - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[p] w KQkq - 0 1"} {
+		if b := ParseFenVariant(fen, Crazyhouse); b != (Board{}) {
+			t.Error("Parsed a Crazyhouse position with too many pawns:", fen)
+		}
+	}
+	// ParseFen plays standard chess, and ignores the pockets
+	if b := ParseFen("4k3/8/8/8/8/8/8/4K3[N] w - - 0 1"); b.Variant() != Standard || b.PocketCount(true, Knight) != 0 {
+		t.Error("ParseFen parsed a Crazyhouse pocket")
+	}
+	b := ParseFenVariant(Startpos, Crazyhouse)
+	if fen := b.ToFen(); fen != "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[] w KQkq - 0 1" {
+		t.Error("The Crazyhouse starting position was serialized as", fen)
+	}
+	b = ParseFenVariant("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR[Qq] w KQkq - 0 1", Standard)
+	if b.Variant() != Standard || b.ToFen() != Startpos || b.Hash() != recomputeBoardHash(&b) {
+		t.Error("Parsing a Crazyhouse FEN as standard chess should drop the pockets")
+	}
+}
+
+func TestParseDrop(t *testing.T) {
+	tests := map[string]string{"N@f3": "N@f3", "n@f3": "N@f3", "P@e4": "P@e4", "Q@a8": "Q@a8"}
+	for input, output := range tests {
+		m, err := ParseMove(input)
+		if err != nil {
+			t.Error("Could not parse drop", input)
+			continue
+		}
+		if m.String() != output || m.Promote() != Nothing || m.From() != 0 {
+			t.Error("Parsed drop", input, "as", m.String())
+		}
+	}
+	for _, input := range []string{"K@e4", "x@e4", "N@i9"} {
+		if _, err := ParseMove(input); err == nil {
+			t.Error("Parsed invalid drop", input)
+		}
+	}
+}
+
+func TestThreeCheckFen(t *testing.T) {
+	tests := []struct {
+		fen       string
+		threefen  string // the expected output of ToFen
+		remaining [2]int
+	}{
+		{Startpos + " +0+0", Startpos + " +0+0", [2]int{3, 3}},
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 +2+1",
+			"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 +2+1", [2]int{1, 2}},
+		// the checks remaining can also be given after the en passant square
+		{"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 3+1 0 2",
+			"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2 +0+2", [2]int{3, 1}},
+	}
+	for _, test := range tests {
+		b := ParseFenVariant(test.fen, ThreeCheck)
+		if fen := b.ToFen(); fen != test.threefen {
+			t.Error("Parsed", test.fen, "but serialized it as", fen)
+		}
+		if b.Variant() != ThreeCheck || b.RemainingChecks(true) != test.remaining[0] ||
+			b.RemainingChecks(false) != test.remaining[1] {
+			t.Error("Incorrect Three-check counts for", test.fen)
+		}
+		if b.Hash() != recomputeBoardHash(&b) {
+			t.Error("Incorrect hash for", test.fen)
+		}
+	}
+	// the checks are part of the hash
+	none, one := ParseFenVariant(Startpos+" +0+0", ThreeCheck), ParseFenVariant(Startpos+" +1+0", ThreeCheck)
+	if none.Hash() == one.Hash() {
+		t.Error("Positions with different check counts have the same hash")
+	}
+	if b := ParseFenVariant(Startpos+" +1+0", Standard); b.Variant() != Standard || b.ToFen() != Startpos {
+		t.Error("Parsing a Three-check FEN as standard chess should drop the check counts")
+	}
+	if b := ParseFen(Startpos + " +1+0"); b.Variant() != Standard || b.ToFen() != Startpos {
+		t.Error("ParseFen should ignore the check counts")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	tests := map[string]string{
+		Startpos:                             "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq - 0 1",
+		"4k3/8/8/8/3pP3/8/8/4K3 b - e3 0 1":  "4k3/8/8/3Pp3/8/8/8/4K3 w - e6 0 1",
+		"r3k3/8/8/8/8/8/8/4K2R w Kq - 0 1":   "4k2r/8/8/8/8/8/8/R3K3 b Qk - 0 1",
+		"4k3/8/8/8/8/8/8/4K3 w - - 0 1 +2+0": "4k3/8/8/8/8/8/8/4K3 b - - 0 1 +0+2",
+		"4k3/8/8/8/8/8/8/4K3[Qnn] w - - 0 1": "4k3/8/8/8/8/8/8/4K3[NNq] b - - 0 1",
+	}
+	for fen, expected := range tests {
+		b := ParseFen(fen)
+		if mirrored := b.Mirror(); mirrored != ParseFen(expected) {
+			t.Error("Mirroring\n", fen, "\ngave\n", mirrored.ToFen(), "\ninstead of\n", expected)
+		}
+	}
+	boards := []Board{
+		ParseFenChess960("1r2k1r1/8/8/8/8/8/8/1R2K1R1 w GBgb - 0 1"),
+		ParseFenVariant("2k5/8/8/8/8/8/8/4K3[QRBNPqrbnp] w - - 0 1", Crazyhouse),
+	}
+	for _, fen := range perftSuitePositions {
+		boards = append(boards, ParseFen(fen))
+	}
+	for _, b := range boards {
+		b.SetPieceSquareTable(testPieceSquareTable())
+		mirrored := b.Mirror()
+		if mirrored.Mirror() != b {
+			t.Error("Mirroring twice did not restore the position\n", b.ToFen())
+		}
+		if mirrored.Hash() != recomputeBoardHash(&mirrored) {
+			t.Error("Incorrect hash after mirroring the position\n", b.ToFen())
+		}
+		score, mirroredScore := b.Score(), mirrored.Score()
+		for phase := Midgame; phase <= Endgame; phase++ {
+			if score.Material[phase] != -mirroredScore.Material[phase] ||
+				score.Squares[phase] != -mirroredScore.Squares[phase] {
+				t.Error("The score", mirroredScore, "of the mirrored position is not the negation of",
+					score, "for position\n", b.ToFen())
+			}
+		}
+		for depth := 1; depth <= 3; depth++ {
+			if Perft(&b, depth) != Perft(&mirrored, depth) {
+				t.Error("Perft differs at depth", depth, "after mirroring the position\n", b.ToFen())
+			}
+		}
+	}
+}
+
+func TestFlipHorizontal(t *testing.T) {
+	b := ParseFen("4k3/8/8/8/3pP3/8/8/4K3 b - e3 0 1")
+	if flipped, err := b.FlipHorizontal(); err != nil || flipped != ParseFen("3k4/8/8/8/3Pp3/8/8/3K4 b - d3 0 1") {
+		t.Error("Flipping gave\n", flipped.ToFen(), "\nwith error", err)
+	}
+	b = ParseFen(Startpos)
+	if _, err := b.FlipHorizontal(); err == nil {
+		t.Error("Flipping a board with castling rights should fail")
+	}
+	for _, fen := range perftSuitePositions {
+		b := ParseFen(fen)
+		flipped, err := b.FlipHorizontal()
+		if err != nil {
+			continue // castling isn't symmetric
+		}
+		if twice, _ := flipped.FlipHorizontal(); twice != b {
+			t.Error("Flipping twice did not restore the position\n", fen)
+		}
+		for depth := 1; depth <= 3; depth++ {
+			if Perft(&b, depth) != Perft(&flipped, depth) {
+				t.Error("Perft differs at depth", depth, "after flipping the position\n", fen)
+			}
+		}
+	}
+}